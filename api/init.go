@@ -8,16 +8,19 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"github.com/orange-cloudfoundry/promconsulfetcher/fetchers"
+	"github.com/orange-cloudfoundry/promconsulfetcher/models"
 	"github.com/orange-cloudfoundry/promconsulfetcher/userdocs"
 )
 
 type Api struct {
 	metFetcher *fetchers.MetricsFetcher
+	outputMode models.OutputMode
 }
 
-func Register(rtr *mux.Router, metFetcher *fetchers.MetricsFetcher, us *userdocs.UserDoc) {
+func Register(rtr *mux.Router, metFetcher *fetchers.MetricsFetcher, outputMode models.OutputMode, us *userdocs.UserDoc) {
 	api := &Api{
 		metFetcher: metFetcher,
+		outputMode: outputMode,
 	}
 
 	handlerMetrics := handlers.CompressHandler(http.HandlerFunc(api.metrics))
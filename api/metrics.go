@@ -1,14 +1,21 @@
 package api
 
 import (
+	"bytes"
 	"fmt"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/prometheus/common/expfmt"
+	log "github.com/sirupsen/logrus"
 
 	"github.com/orange-cloudfoundry/promconsulfetcher/errors"
+	"github.com/orange-cloudfoundry/promconsulfetcher/fetchers"
+	"github.com/orange-cloudfoundry/promconsulfetcher/models"
 )
 
 func (a Api) metrics(w http.ResponseWriter, req *http.Request) {
@@ -21,6 +28,29 @@ func (a Api) metrics(w http.ResponseWriter, req *http.Request) {
 		w.Write([]byte(fmt.Sprintf("%d %s: You must set consul query", http.StatusBadRequest, http.StatusText(http.StatusBadRequest))))
 		return
 	}
+	serviceSearch, err := models.SearchToServiceSearch(consulQuery)
+	if err != nil {
+		writeErrFetch(w, errors.ErrBadRequest(err.Error()))
+		return
+	}
+	if datacenters := strings.TrimSpace(req.URL.Query().Get("datacenters")); datacenters != "" {
+		serviceSearch.Datacenter = datacenters
+	}
+	serviceSearch.Filter = strings.TrimSpace(req.URL.Query().Get("filter"))
+	if serviceSearch.Filter != "" && serviceSearch.PreparedQuery {
+		writeErrFetch(w, errors.ErrBadRequest("filter can't be used with a prepared query"))
+		return
+	}
+	if err := models.ValidFilter(serviceSearch.Filter); err != nil {
+		writeErrFetch(w, errors.ErrBadRequest(err.Error()))
+		return
+	}
+	serviceSearch.Health = strings.TrimSpace(req.URL.Query().Get("health"))
+	if err := models.ValidHealth(serviceSearch.Health); err != nil {
+		writeErrFetch(w, errors.ErrBadRequest(err.Error()))
+		return
+	}
+
 	metricPathDefault := strings.TrimSpace(req.URL.Query().Get("metric_path"))
 	if metricPathDefault == "" {
 		metricPathDefault = "/metrics"
@@ -42,22 +72,123 @@ func (a Api) metrics(w http.ResponseWriter, req *http.Request) {
 		headersMetrics.Set("Authorization", auth)
 	}
 
-	metrics, err := a.metFetcher.Metrics(consulQuery, metricPathDefault, schemeDefault, onlyAppMetrics, headersMetrics)
+	metricsPortDefault := 0
+	if metricsPortParam := strings.TrimSpace(req.URL.Query().Get("metric_port")); metricsPortParam != "" {
+		metricsPortDefault, err = strconv.Atoi(metricsPortParam)
+		if err != nil || metricsPortDefault <= 0 || metricsPortDefault > 65535 {
+			writeErrFetch(w, errors.ErrBadRequest(fmt.Sprintf("invalid metric_port %q: must be a valid port", metricsPortParam)))
+			return
+		}
+	}
+
+	addressPolicy, err := models.ParseAddressPolicy(req.URL.Query().Get("address"))
+	if err != nil {
+		writeErrFetch(w, errors.ErrBadRequest(err.Error()))
+		return
+	}
+
+	scrapeDefaults := models.ScrapeConfig{
+		Scheme:        schemeDefault,
+		MetricPath:    metricPathDefault,
+		MetricsPort:   metricsPortDefault,
+		AddressPolicy: addressPolicy,
+		Timeout:       prometheusScrapeTimeout(req),
+	}
+	format := expfmt.NegotiateIncludingOpenMetrics(req.Header)
+	if a.outputMode == models.OutputStream && fetchers.StreamableFormat(format) {
+		a.streamMetrics(w, req, serviceSearch, scrapeDefaults, onlyAppMetrics, headersMetrics, format)
+		return
+	}
+	metrics, err := a.metFetcher.Metrics(req.Context(), serviceSearch, scrapeDefaults, onlyAppMetrics, headersMetrics)
 	if err != nil {
 		if errFetch, ok := err.(*errors.ErrFetch); ok {
-			w.WriteHeader(errFetch.Code)
-			w.Write([]byte(errFetch.Error()))
+			writeErrFetch(w, errFetch)
 			return
 		}
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(fmt.Sprintf("%d %s: %s", http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError), err.Error())))
 		return
 	}
+	w.Header().Set("Content-Type", string(format))
 	w.WriteHeader(http.StatusOK)
-	for _, metric := range metrics {
-		expfmt.MetricFamilyToText(w, metric)
-		w.Write([]byte("\n"))
+	// each family is encoded in a buffer to not send lines of a family which failed to be encoded
+	buf := &bytes.Buffer{}
+	encoder := expfmt.NewEncoder(buf, format, expfmt.WithCreatedLines())
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		metric := metrics[name]
+		buf.Reset()
+		if err := encoder.Encode(metric); err != nil {
+			log.WithField("metric", metric.GetName()).Warningf("Cannot encode metric: %s", err.Error())
+			continue
+		}
+		w.Write(buf.Bytes())
+	}
+	buf.Reset()
+	if closer, ok := encoder.(expfmt.Closer); ok {
+		closer.Close()
 	}
+	w.Write(buf.Bytes())
+}
+
+// streamMetrics writes metrics encoded in order of instances once all are scraped, nothing is written when
+// metrics fetcher gives an error so it can still be sent.
+func (a Api) streamMetrics(w http.ResponseWriter, req *http.Request, serviceSearch models.ServiceSearch, scrapeDefaults models.ScrapeConfig, onlyAppMetrics bool, headers http.Header, format expfmt.Format) {
+	lw := &lazyHeaderWriter{ResponseWriter: w, format: format}
+	err := a.metFetcher.StreamMetrics(req.Context(), serviceSearch, scrapeDefaults, onlyAppMetrics, headers, lw, format)
+	if err != nil && lw.written {
+		log.Warningf("Cannot stream metrics: %s", err.Error())
+		return
+	}
+	if err != nil {
+		if errFetch, ok := err.(*errors.ErrFetch); ok {
+			writeErrFetch(w, errFetch)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("%d %s: %s", http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError), err.Error())))
+		return
+	}
+	lw.writeHeader()
+}
+
+// lazyHeaderWriter sends content type header and status only on first write
+type lazyHeaderWriter struct {
+	http.ResponseWriter
+	format  expfmt.Format
+	written bool
+}
+
+func (w *lazyHeaderWriter) writeHeader() {
+	if w.written {
+		return
+	}
+	w.written = true
+	w.Header().Set("Content-Type", string(w.format))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (w *lazyHeaderWriter) Write(p []byte) (int, error) {
+	w.writeHeader()
+	return w.ResponseWriter.Write(p)
+}
+
+// prometheusScrapeTimeout gives scrape timeout sent by prometheus, it returns 0 if not set or invalid
+func prometheusScrapeTimeout(req *http.Request) time.Duration {
+	timeoutSeconds, err := strconv.ParseFloat(req.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"), 64)
+	if err != nil || timeoutSeconds <= 0 {
+		return 0
+	}
+	return time.Duration(timeoutSeconds * float64(time.Second))
+}
+
+func writeErrFetch(w http.ResponseWriter, errFetch *errors.ErrFetch) {
+	w.WriteHeader(errFetch.Code)
+	w.Write([]byte(errFetch.Error()))
 }
 
 func forceOnlyForApp(next http.Handler) http.Handler {
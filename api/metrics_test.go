@@ -0,0 +1,164 @@
+package api_test
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/orange-cloudfoundry/promconsulfetcher/api"
+	"github.com/orange-cloudfoundry/promconsulfetcher/clients"
+	"github.com/orange-cloudfoundry/promconsulfetcher/config"
+	"github.com/orange-cloudfoundry/promconsulfetcher/fetchers"
+	"github.com/orange-cloudfoundry/promconsulfetcher/models"
+	"github.com/orange-cloudfoundry/promconsulfetcher/scrapers"
+)
+
+type fakeRoutesFetcher struct {
+	routes models.Routes
+}
+
+func (f fakeRoutesFetcher) Routes(search models.ServiceSearch) (models.Routes, error) {
+	return f.routes, nil
+}
+
+func (f fakeRoutesFetcher) Datacenters() ([]string, error) {
+	return []string{}, nil
+}
+
+var _ = Describe("Metrics", func() {
+	const content = "# HELP foo foo help\n# TYPE foo counter\nfoo 1\n# TYPE bar gauge\nbar 2\n"
+	var server *ghttp.Server
+	var route *models.Route
+
+	request := func(outputMode models.OutputMode, accept string) *httptest.ResponseRecorder {
+		c, err := config.DefaultConfig()
+		Expect(err).ToNot(HaveOccurred())
+		scraper := scrapers.NewScraper(clients.NewBackendFactory(*c), *c)
+		metFetcher := fetchers.NewMetricsFetcher(scraper, fakeRoutesFetcher{routes: models.Routes{route}}, *c)
+		rtr := mux.NewRouter()
+		api.Register(rtr, metFetcher, outputMode, nil)
+
+		req := httptest.NewRequest(http.MethodGet, "/v1/services/app/metrics", nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rec := httptest.NewRecorder()
+		rtr.ServeHTTP(rec, req)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		return rec
+	}
+
+	BeforeEach(func() {
+		server = ghttp.NewServer()
+		server.RouteToHandler(http.MethodGet, "/metrics", ghttp.RespondWith(http.StatusOK, content))
+		serverURL, err := url.Parse(server.URL())
+		Expect(err).ToNot(HaveOccurred())
+		host, portStr, err := net.SplitHostPort(serverURL.Host)
+		Expect(err).ToNot(HaveOccurred())
+		port, err := strconv.Atoi(portStr)
+		Expect(err).ToNot(HaveOccurred())
+		route = &models.Route{
+			Node:           "node",
+			ServiceName:    "app",
+			ServiceID:      "app-1",
+			ServiceAddress: host,
+			ServicePort:    port,
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	for _, outputMode := range []models.OutputMode{models.OutputMerged, models.OutputStream} {
+		outputMode := outputMode
+
+		Context("in "+string(outputMode)+" output mode", func() {
+			It("writes text format when openmetrics or protobuf is not accepted", func() {
+				for _, accept := range []string{"", "text/plain", "*/*"} {
+					rec := request(outputMode, accept)
+					Expect(expfmt.ResponseFormat(rec.Header()).FormatType()).To(BeEquivalentTo(expfmt.TypeTextPlain))
+
+					var parser expfmt.TextParser
+					metricsGroup, err := parser.TextToMetricFamilies(rec.Body)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(metricsGroup["foo"].GetHelp()).To(Equal("foo help"))
+					Expect(metricsGroup["bar"].Metric).To(HaveLen(1))
+				}
+			})
+
+			It("writes openmetrics format ending with EOF when accepted", func() {
+				rec := request(outputMode, "application/openmetrics-text;version=1.0.0,text/plain;version=0.0.4;q=0.5")
+				Expect(rec.Header().Get("Content-Type")).To(HavePrefix("application/openmetrics-text; version=1.0.0"))
+				Expect(rec.Body.String()).To(ContainSubstring("# TYPE bar gauge\n"))
+				Expect(rec.Body.String()).To(HaveSuffix("# EOF\n"))
+			})
+
+			It("writes delimited protobuf format when accepted", func() {
+				rec := request(outputMode, "application/vnd.google.protobuf;proto=io.prometheus.client.MetricFamily;encoding=delimited")
+				format := expfmt.ResponseFormat(rec.Header())
+				Expect(format.FormatType()).To(BeEquivalentTo(expfmt.TypeProtoDelim))
+
+				names := make([]string, 0)
+				decoder := expfmt.NewDecoder(bufio.NewReader(rec.Body), format)
+				for {
+					mf := &dto.MetricFamily{}
+					err := decoder.Decode(mf)
+					if errors.Is(err, io.EOF) {
+						break
+					}
+					Expect(err).ToNot(HaveOccurred())
+					names = append(names, mf.GetName())
+				}
+				Expect(names).To(ContainElement("foo"))
+				Expect(names).To(ContainElement("bar"))
+			})
+		})
+	}
+
+	It("does not write lines of a family which can't be encoded", func() {
+		buf := &bytes.Buffer{}
+		format := expfmt.NewFormat(expfmt.TypeProtoDelim)
+		enc := expfmt.NewEncoder(buf, format)
+		// counter without counter value can't be encoded in text formats
+		Expect(enc.Encode(&dto.MetricFamily{
+			Name:   proto.String("broken"),
+			Help:   proto.String("broken help"),
+			Type:   dto.MetricType_COUNTER.Enum(),
+			Metric: []*dto.Metric{{Gauge: &dto.Gauge{Value: proto.Float64(1)}}},
+		})).To(Succeed())
+		Expect(enc.Encode(&dto.MetricFamily{
+			Name:   proto.String("foo"),
+			Type:   dto.MetricType_GAUGE.Enum(),
+			Metric: []*dto.Metric{{Gauge: &dto.Gauge{Value: proto.Float64(1)}}},
+		})).To(Succeed())
+		server.RouteToHandler(http.MethodGet, "/metrics", ghttp.RespondWith(http.StatusOK, buf.Bytes(), http.Header{
+			"Content-Type": {string(format)},
+		}))
+
+		rec := request(models.OutputMerged, "")
+		Expect(rec.Body.String()).ToNot(ContainSubstring("broken"))
+		var parser expfmt.TextParser
+		metricsGroup, err := parser.TextToMetricFamilies(rec.Body)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(metricsGroup).To(HaveKey("foo"))
+
+		rec = request(models.OutputMerged, "application/openmetrics-text;version=1.0.0")
+		Expect(rec.Body.String()).ToNot(ContainSubstring("broken"))
+		Expect(rec.Body.String()).To(HaveSuffix("# EOF\n"))
+	})
+})
@@ -4,14 +4,31 @@ import (
 	"crypto/tls"
 	"net"
 	"net/http"
+	"sync"
 	"time"
 
+	log "github.com/sirupsen/logrus"
+
 	"github.com/orange-cloudfoundry/promconsulfetcher/config"
 	"github.com/orange-cloudfoundry/promconsulfetcher/models"
 )
 
+// tlsProfile identifies tls config used to connect on an instance, transports are cached by profile
+type tlsProfile struct {
+	// index of backend tls config, -1 for default one
+	index      int
+	serverName string
+}
+
 type BackendFactory struct {
-	factory FactoryRoundTripper
+	factory     FactoryRoundTripper
+	backendsTLS []config.BackendTLS
+	// factories are the factories for each backend tls config with same index
+	factories []FactoryRoundTripper
+
+	transports *transportPool
+	// unknownProfiles are names of tls profiles not found in configuration which have already been logged
+	unknownProfiles sync.Map
 }
 
 func NewBackendFactory(c config.Config) *BackendFactory {
@@ -22,28 +39,116 @@ func NewBackendFactory(c config.Config) *BackendFactory {
 	if c.Backends.ClientAuthCertificate != nil {
 		backendTLSConfig.Certificates = []tls.Certificate{*c.Backends.ClientAuthCertificate}
 	}
+	factories := make([]FactoryRoundTripper, 0, len(c.Backends.TLS))
+	for _, backendTLS := range c.Backends.TLS {
+		tlsConfig := &tls.Config{
+			InsecureSkipVerify: c.SkipSSLValidation || backendTLS.SkipSSLValidation,
+			RootCAs:            c.CAPool,
+			Certificates:       backendTLSConfig.Certificates,
+		}
+		if backendTLS.CAPool != nil {
+			tlsConfig.RootCAs = backendTLS.CAPool
+		}
+		if backendTLS.ClientAuthCertificate != nil {
+			tlsConfig.Certificates = []tls.Certificate{*backendTLS.ClientAuthCertificate}
+		}
+		factories = append(factories, FactoryRoundTripper{Template: transportTemplate(c, tlsConfig)})
+	}
 	return &BackendFactory{
-		factory: FactoryRoundTripper{
-			Template: &http.Transport{
-				DialContext: (&net.Dialer{
-					Timeout:   5 * time.Second,
-					KeepAlive: 30 * time.Second,
-					DualStack: true,
-				}).DialContext,
-				DisableKeepAlives:   c.DisableKeepAlives,
-				MaxIdleConns:        c.MaxIdleConns,
-				IdleConnTimeout:     90 * time.Second, // setting the value to golang default transport
-				MaxIdleConnsPerHost: c.MaxIdleConnsPerHost,
-				DisableCompression:  false,
-				TLSClientConfig:     backendTLSConfig,
-			},
-		},
+		factory:     FactoryRoundTripper{Template: transportTemplate(c, backendTLSConfig)},
+		backendsTLS: c.Backends.TLS,
+		factories:   factories,
+		transports: newTransportPool(
+			c.Backends.TransportPool.MaxTransports,
+			c.Backends.TransportPool.IdleTimeout.Duration(),
+		),
 	}
 }
 
-func (f BackendFactory) NewClient(route *models.Route) *http.Client {
+func transportTemplate(c config.Config, tlsConfig *tls.Config) *http.Transport {
+	return &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout:   5 * time.Second,
+			KeepAlive: 30 * time.Second,
+			DualStack: true,
+		}).DialContext,
+		DisableKeepAlives:   c.DisableKeepAlives,
+		MaxIdleConns:        c.MaxIdleConns,
+		IdleConnTimeout:     90 * time.Second, // setting the value to golang default transport
+		MaxIdleConnsPerHost: c.MaxIdleConnsPerHost,
+		DisableCompression:  false,
+		TLSClientConfig:     tlsConfig,
+	}
+}
+
+// NewClient gives a client to scrape route on host with tls profile of route,
+// transport is taken from pool to reuse connections of previous scrapes with same tls profile and host.
+func (f *BackendFactory) NewClient(route *models.Route, host string) *http.Client {
+	profile := f.tlsProfile(route)
+	transport := f.transports.get(transportKey{profile: profile, host: host}, func() http.RoundTripper {
+		factory := f.factory
+		if profile.index >= 0 {
+			factory = f.factories[profile.index]
+		}
+		return factory.New(profile.serverName)
+	})
 	return &http.Client{
-		Transport: f.factory.New(""),
+		Transport: transport,
 		Timeout:   30 * time.Second,
 	}
 }
+
+// tlsProfile gives tls profile of route, profile is, by order of precedence, the one named in service meta or tags
+// and then the first one matching service name and tag. Server name set in service meta or tags overrides
+// the one of profile.
+func (f *BackendFactory) tlsProfile(route *models.Route) tlsProfile {
+	profile := tlsProfile{index: -1}
+	if name, ok := route.FindSetting(models.TLSProfileSetting); ok && name != "" {
+		for i, backendTLS := range f.backendsTLS {
+			if backendTLS.Name == name {
+				profile.index = i
+				break
+			}
+		}
+		if profile.index < 0 {
+			if _, logged := f.unknownProfiles.LoadOrStore(name, true); !logged {
+				log.Warnf("Tls profile %s of service name %s not found in configuration", name, route.ServiceName)
+			}
+		}
+	}
+	if profile.index < 0 {
+		for i, backendTLS := range f.backendsTLS {
+			if backendTLSMatch(backendTLS, route) {
+				profile.index = i
+				break
+			}
+		}
+	}
+	if profile.index >= 0 {
+		profile.serverName = f.backendsTLS[profile.index].ServerName
+	}
+	if serverName, ok := route.FindSetting(models.TLSServerNameSetting); ok && serverName != "" {
+		profile.serverName = serverName
+	}
+	return profile
+}
+
+// backendTLSMatch checks that route matches service name and tag of backend tls config,
+// a config only selectable by name never matches.
+func backendTLSMatch(backendTLS config.BackendTLS, route *models.Route) bool {
+	if backendTLS.ServiceName == "" && backendTLS.Tag == "" {
+		return false
+	}
+	if backendTLS.ServiceName != "" && backendTLS.ServiceName != route.ServiceName {
+		return false
+	}
+	if backendTLS.Tag == "" {
+		return true
+	}
+	for _, tag := range route.ServiceTags {
+		if tag == backendTLS.Tag {
+			return true
+		}
+	}
+	return false
+}
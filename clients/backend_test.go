@@ -1,12 +1,15 @@
 package clients_test
 
 import (
+	"bytes"
 	"crypto/x509"
 	"net/http"
+	"strings"
 	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
 
 	"github.com/orange-cloudfoundry/promconsulfetcher/clients"
 	"github.com/orange-cloudfoundry/promconsulfetcher/config"
@@ -27,7 +30,7 @@ var _ = Describe("Backend", func() {
 				MaxIdleConnsPerHost: 100,
 			})
 
-			client := factory.NewClient(&models.Route{})
+			client := factory.NewClient(&models.Route{}, "")
 
 			Expect(client).ToNot(BeNil())
 			Expect(client.Timeout).To(Equal(30 * time.Second))
@@ -38,5 +41,94 @@ var _ = Describe("Backend", func() {
 			Expect(httpTrans.MaxIdleConns).To(Equal(100))
 			Expect(httpTrans.MaxIdleConnsPerHost).To(Equal(100))
 		})
+
+		Context("with backends tls", func() {
+			var factory *clients.BackendFactory
+			BeforeEach(func() {
+				factory = clients.NewBackendFactory(config.Config{
+					CAPool: &x509.CertPool{},
+					Backends: config.BackendConfig{
+						TLS: []config.BackendTLS{
+							{ServiceName: "app", Tag: "secure", ServerName: "app.secure.local"},
+							{ServiceName: "app", ServerName: "app.local"},
+							{Name: "named", ServerName: "named.local"},
+						},
+					},
+				})
+			})
+			serverName := func(client *http.Client) string {
+				return client.Transport.(*http.Transport).TLSClientConfig.ServerName
+			}
+
+			It("should use first profile matching service name and tag", func() {
+				Expect(serverName(factory.NewClient(&models.Route{
+					ServiceName: "app",
+					ServiceTags: []string{"secure"},
+				}, ""))).To(Equal("app.secure.local"))
+				Expect(serverName(factory.NewClient(&models.Route{ServiceName: "app"}, ""))).To(Equal("app.local"))
+				Expect(serverName(factory.NewClient(&models.Route{ServiceName: "other"}, ""))).To(Equal(""))
+			})
+
+			It("should use profile and server name given in service meta", func() {
+				Expect(serverName(factory.NewClient(&models.Route{
+					ServiceName: "other",
+					ServiceMeta: map[string]string{"promconsulfetcher_tls_profile": "named"},
+				}, ""))).To(Equal("named.local"))
+				Expect(serverName(factory.NewClient(&models.Route{
+					ServiceName: "app",
+					ServiceMeta: map[string]string{"promconsulfetcher_tls_server_name": "meta.local"},
+				}, ""))).To(Equal("meta.local"))
+			})
+
+			It("should warn once per unknown profile and fall back on matching profile", func() {
+				logs := &bytes.Buffer{}
+				out := log.StandardLogger().Out
+				log.SetOutput(logs)
+				defer log.SetOutput(out)
+
+				for _, name := range []string{"missing", "missing", "other-missing"} {
+					Expect(serverName(factory.NewClient(&models.Route{
+						ServiceName: "app",
+						ServiceMeta: map[string]string{"promconsulfetcher_tls_profile": name},
+					}, ""))).To(Equal("app.local"))
+				}
+				Expect(strings.Count(logs.String(), "Tls profile missing ")).To(Equal(1))
+				Expect(strings.Count(logs.String(), "Tls profile other-missing ")).To(Equal(1))
+			})
+
+			It("should share transport between clients with same profile", func() {
+				first := factory.NewClient(&models.Route{ServiceName: "app", ServiceID: "app-1"}, "")
+				second := factory.NewClient(&models.Route{ServiceName: "app", ServiceID: "app-2"}, "")
+				other := factory.NewClient(&models.Route{ServiceName: "other"}, "")
+
+				Expect(first.Transport).To(BeIdenticalTo(second.Transport))
+				Expect(first.Transport).ToNot(BeIdenticalTo(other.Transport))
+			})
+		})
+
+		Context("with transport pool", func() {
+			It("should reuse transport per host and evict least recently used one when pool is full", func() {
+				factory := clients.NewBackendFactory(config.Config{
+					CAPool: &x509.CertPool{},
+					Backends: config.BackendConfig{
+						TransportPool: config.TransportPoolConfig{MaxTransports: 2},
+					},
+				})
+				route := &models.Route{ServiceName: "app"}
+
+				first := factory.NewClient(route, "10.0.0.1:8080").Transport
+				Expect(factory.NewClient(route, "10.0.0.1:8080").Transport).To(BeIdenticalTo(first))
+
+				second := factory.NewClient(route, "10.0.0.2:8080").Transport
+				Expect(second).ToNot(BeIdenticalTo(first))
+
+				// first is used again so second is the least recently used one
+				Expect(factory.NewClient(route, "10.0.0.1:8080").Transport).To(BeIdenticalTo(first))
+				factory.NewClient(route, "10.0.0.3:8080")
+
+				Expect(factory.NewClient(route, "10.0.0.1:8080").Transport).To(BeIdenticalTo(first))
+				Expect(factory.NewClient(route, "10.0.0.2:8080").Transport).ToNot(BeIdenticalTo(second))
+			})
+		})
 	})
 })
@@ -0,0 +1,111 @@
+package clients
+
+import (
+	"container/list"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/orange-cloudfoundry/promconsulfetcher/metrics"
+)
+
+const (
+	evictedIdle     = "idle"
+	evictedCapacity = "capacity"
+)
+
+// transportKey identifies a pooled transport
+type transportKey struct {
+	profile tlsProfile
+	host    string
+}
+
+type pooledTransport struct {
+	key       transportKey
+	transport http.RoundTripper
+	lastUsed  time.Time
+}
+
+// transportPool keeps transports to reuse their connections, pool is bounded to maxSize transports and
+// least recently used transport is evicted when pool is full, transports not used during idle timeout
+// are evicted when pool is used. Evicted transports have their idle connections closed.
+// A maxSize or an idleTimeout of 0 means no limit.
+type transportPool struct {
+	maxSize     int
+	idleTimeout time.Duration
+
+	mu         sync.Mutex
+	transports map[transportKey]*list.Element
+	// lru has most recently used transports at front
+	lru *list.List
+}
+
+func newTransportPool(maxSize int, idleTimeout time.Duration) *transportPool {
+	return &transportPool{
+		maxSize:     maxSize,
+		idleTimeout: idleTimeout,
+		transports:  make(map[transportKey]*list.Element),
+		lru:         list.New(),
+	}
+}
+
+// get gives transport for key, transport is created with create if not in pool
+func (p *transportPool) get(key transportKey, create func() http.RoundTripper) http.RoundTripper {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	p.evictIdle(now)
+	if element, ok := p.transports[key]; ok {
+		pooled := element.Value.(*pooledTransport)
+		pooled.lastUsed = now
+		p.lru.MoveToFront(element)
+		return pooled.transport
+	}
+	if p.maxSize > 0 && p.lru.Len() >= p.maxSize {
+		p.evict(p.lru.Back(), evictedCapacity)
+	}
+	pooled := &pooledTransport{
+		key:       key,
+		transport: create(),
+		lastUsed:  now,
+	}
+	p.transports[key] = p.lru.PushFront(pooled)
+	metrics.BackendTransportsCreatedTotal.Inc()
+	metrics.BackendTransports.Set(float64(p.lru.Len()))
+	return pooled.transport
+}
+
+func (p *transportPool) evictIdle(now time.Time) {
+	if p.idleTimeout <= 0 {
+		return
+	}
+	for element := p.lru.Back(); element != nil; element = p.lru.Back() {
+		if now.Sub(element.Value.(*pooledTransport).lastUsed) <= p.idleTimeout {
+			return
+		}
+		p.evict(element, evictedIdle)
+	}
+}
+
+func (p *transportPool) evict(element *list.Element, reason string) {
+	pooled := p.lru.Remove(element).(*pooledTransport)
+	delete(p.transports, pooled.key)
+	// connections in use are closed by transport idle timeout once released
+	if closer, ok := pooled.transport.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+	metrics.BackendTransportsEvictedTotal.WithLabelValues(reason).Inc()
+	metrics.BackendTransports.Set(float64(p.lru.Len()))
+}
+
+// TraceConnections gives request which counts connections used by request in metrics, reused or not
+func TraceConnections(req *http.Request) *http.Request {
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			metrics.BackendConnectionsTotal.WithLabelValues(strconv.FormatBool(info.Reused)).Inc()
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
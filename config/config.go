@@ -9,8 +9,11 @@ import (
 	"strings"
 	"time"
 
+	"github.com/prometheus/common/model"
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
+
+	"github.com/orange-cloudfoundry/promconsulfetcher/models"
 )
 
 type ConsulConfig struct {
@@ -18,9 +21,18 @@ type ConsulConfig struct {
 	Scheme           string                  `yaml:"scheme"`
 	DataCenter       string                  `yaml:"datacenter"`
 	Token            string                  `yaml:"token"`
+	Namespace        string                  `yaml:"namespace"`
+	Partition        string                  `yaml:"partition"`
 	TLS              *ClientTLS              `yaml:"tls"`
 	HTTPAuth         *EndpointHTTPAuthConfig `yaml:"http_auth"`
 	EndpointWaitTime yamlTimeDur             `yaml:"endpoint_wait_time"`
+	Health           string                  `yaml:"health"`
+	Cache            RoutesCacheConfig       `yaml:"cache"`
+}
+
+type RoutesCacheConfig struct {
+	Enabled     bool        `yaml:"enabled"`
+	IdleTimeout yamlTimeDur `yaml:"idle_timeout"`
 }
 
 type EndpointHTTPAuthConfig struct {
@@ -40,6 +52,69 @@ type BackendConfig struct {
 	MaxConns              int64            `yaml:"max_conns"`
 
 	TLSPem `yaml:",inline"` // embed to get cert_chain and private_key for client authentication
+
+	TLS []BackendTLS `yaml:"tls"`
+
+	TransportPool TransportPoolConfig `yaml:"transport_pool"`
+}
+
+// TransportPoolConfig bounds transports kept to reuse connections on instances, 0 means no limit
+type TransportPoolConfig struct {
+	MaxTransports int         `yaml:"max_transports"`
+	IdleTimeout   yamlTimeDur `yaml:"idle_timeout"`
+}
+
+// BackendTLS is a tls profile used instead of default one to connect on instances of services
+// matching service name and tag, or selecting it by name in their meta or tags.
+type BackendTLS struct {
+	Name              string `yaml:"name"`
+	ServiceName       string `yaml:"service_name"`
+	Tag               string `yaml:"tag"`
+	ServerName        string `yaml:"server_name"`
+	CACerts           string `yaml:"ca_certs"`
+	SkipSSLValidation bool   `yaml:"skip_ssl_validation"`
+
+	TLSPem `yaml:",inline"` // embed to get cert_chain and private_key for client authentication
+
+	CAPool                *x509.CertPool   `yaml:"-"`
+	ClientAuthCertificate *tls.Certificate `yaml:"-"`
+}
+
+func (b *BackendTLS) process() error {
+	if b.Name == "" && b.ServiceName == "" && b.Tag == "" {
+		return fmt.Errorf("at least one of name, service_name or tag must be set")
+	}
+	if b.CACerts != "" {
+		b.CAPool = x509.NewCertPool()
+		if ok := b.CAPool.AppendCertsFromPEM([]byte(b.CACerts)); !ok {
+			return fmt.Errorf("Error while adding ca_certs to cert pool: \n%s\n", b.CACerts)
+		}
+	}
+	if b.CertChain != "" && b.PrivateKey != "" {
+		certificate, err := tls.X509KeyPair([]byte(b.CertChain), []byte(b.PrivateKey))
+		if err != nil {
+			return fmt.Errorf("Error loading key pair: %s", err.Error())
+		}
+		b.ClientAuthCertificate = &certificate
+	}
+	return nil
+}
+
+type ScrapeDedupConfig struct {
+	Enabled   bool        `yaml:"enabled"`
+	ResultTTL yamlTimeDur `yaml:"result_ttl"`
+}
+
+// LimitsConfig protects prometheus from instances giving too many samples or too big labels, 0 means no limit
+type LimitsConfig struct {
+	SampleLimit           int `yaml:"sample_limit"`
+	ResponseSampleLimit   int `yaml:"response_sample_limit"`
+	LabelLimit            int `yaml:"label_limit"`
+	LabelNameLengthLimit  int `yaml:"label_name_length_limit"`
+	LabelValueLengthLimit int `yaml:"label_value_length_limit"`
+
+	BodySizeLimit           int64 `yaml:"body_size_limit"`
+	CompressedBodySizeLimit int64 `yaml:"compressed_body_size_limit"`
 }
 
 type yamlTimeDur time.Duration
@@ -117,6 +192,24 @@ type Config struct {
 
 	BaseURL string `yaml:"base_url"`
 
+	AddressPolicy models.AddressPolicy `yaml:"address_policy"`
+
+	ScrapeTimeoutMargin yamlTimeDur `yaml:"scrape_timeout_margin"`
+	ScrapeConcurrency   int         `yaml:"scrape_concurrency"`
+	MaxScrapesInFlight  int         `yaml:"max_scrapes_in_flight"`
+
+	ScrapeDedup ScrapeDedupConfig `yaml:"scrape_dedup"`
+
+	Limits LimitsConfig `yaml:"limits"`
+
+	OutputMode           models.OutputMode      `yaml:"output_mode"`
+	MetricFamilyConflict models.FamilyConflict  `yaml:"metric_family_conflict"`
+	DuplicateSeries      models.DuplicateSeries `yaml:"duplicate_series"`
+
+	RouteLabels          models.RouteLabelsConfig `yaml:"route_labels"`
+	ConsulLabels         models.ConsulLabels      `yaml:"consul_labels"`
+	MetricRelabelConfigs models.RelabelConfigs    `yaml:"metric_relabel_configs"`
+
 	ExternalExporters ExternalExporters `yaml:"external_exporters"`
 }
 
@@ -129,14 +222,29 @@ var defaultConfig = Config{
 		TLS:              nil,
 		HTTPAuth:         nil,
 		EndpointWaitTime: 0,
+		Cache: RoutesCacheConfig{
+			Enabled:     false,
+			IdleTimeout: yamlTimeDur(10 * time.Minute),
+		},
 	},
 	Logging:             Log{},
 	Port:                8085,
 	HealthCheckPort:     8080,
-	DisableKeepAlives:   true,
+	DisableKeepAlives:   false,
 	MaxIdleConns:        100,
 	MaxIdleConnsPerHost: 2,
+	Backends: BackendConfig{
+		TransportPool: TransportPoolConfig{
+			MaxTransports: 1000,
+			IdleTimeout:   yamlTimeDur(5 * time.Minute),
+		},
+	},
 	BaseURL:             "http://localhost:8085",
+	ScrapeTimeoutMargin: yamlTimeDur(500 * time.Millisecond),
+	ScrapeConcurrency:   5,
+	ScrapeDedup: ScrapeDedupConfig{
+		Enabled: true,
+	},
 }
 
 func DefaultConfig() (*Config, error) {
@@ -146,6 +254,15 @@ func DefaultConfig() (*Config, error) {
 
 func (c *Config) Process() error {
 	c.BaseURL = strings.TrimSuffix(c.BaseURL, "/")
+	if err := models.ValidHealth(c.ConsulConfig.Health); err != nil {
+		return fmt.Errorf("Error on consul config: %s", err.Error())
+	}
+	if err := c.checkLabelNames(); err != nil {
+		return err
+	}
+	if c.ScrapeConcurrency <= 0 {
+		return fmt.Errorf("scrape_concurrency must be greater than 0")
+	}
 	if c.Backends.CertChain != "" && c.Backends.PrivateKey != "" {
 		certificate, err := tls.X509KeyPair([]byte(c.Backends.CertChain), []byte(c.Backends.PrivateKey))
 		if err != nil {
@@ -154,6 +271,20 @@ func (c *Config) Process() error {
 		}
 		c.Backends.ClientAuthCertificate = &certificate
 	}
+	names := make(map[string]bool)
+	for i := range c.Backends.TLS {
+		backendTLS := &c.Backends.TLS[i]
+		if err := backendTLS.process(); err != nil {
+			return fmt.Errorf("Error on backends tls %d: %s", i, err.Error())
+		}
+		if backendTLS.Name == "" {
+			continue
+		}
+		if names[backendTLS.Name] {
+			return fmt.Errorf("Error on backends tls %d: name %s is already used", i, backendTLS.Name)
+		}
+		names[backendTLS.Name] = true
+	}
 
 	if c.EnableSSL {
 		if c.TLSPEM.PrivateKey == "" || c.TLSPEM.CertChain == "" {
@@ -174,6 +305,26 @@ func (c *Config) Process() error {
 	return nil
 }
 
+// checkLabelNames checks that labels from consul never have the same name as route labels,
+// consul labels only available during relabeling are not injected and can't collide.
+func (c *Config) checkLabelNames() error {
+	routeNames := make(map[string]string)
+	for _, name := range models.RouteLabelNames {
+		if newName, ok := c.RouteLabels.Name(name); ok {
+			routeNames[newName] = name
+		}
+	}
+	for _, name := range c.ConsulLabels.Names() {
+		if strings.HasPrefix(name, model.ReservedLabelPrefix) {
+			continue
+		}
+		if routeName, ok := routeNames[name]; ok {
+			return fmt.Errorf("Error on consul labels: label %s is already used by route label %s", name, routeName)
+		}
+	}
+	return nil
+}
+
 func (c *Config) buildCertPool() error {
 	certPool, err := x509.SystemCertPool()
 	if err != nil {
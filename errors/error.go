@@ -30,6 +30,13 @@ func ErrNoEndpointFound(appIdOrPath, endpoint string) *ErrFetch {
 	}
 }
 
+func ErrBadRequest(message string) *ErrFetch {
+	return &ErrFetch{
+		Code:    http.StatusBadRequest,
+		Message: message,
+	}
+}
+
 type ErrFetch struct {
 	Code    int
 	Message string
@@ -0,0 +1,106 @@
+package fetchers_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/onsi/gomega/ghttp"
+	dto "github.com/prometheus/client_model/go"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/orange-cloudfoundry/promconsulfetcher/clients"
+	"github.com/orange-cloudfoundry/promconsulfetcher/fetchers"
+	"github.com/orange-cloudfoundry/promconsulfetcher/models"
+	"github.com/orange-cloudfoundry/promconsulfetcher/scrapers"
+)
+
+// datacentersRoutesFetcher gives routes by datacenter, a datacenter with an error can't be reached
+type datacentersRoutesFetcher struct {
+	datacenters []string
+	routes      map[string]models.Routes
+	errs        map[string]error
+}
+
+func (f datacentersRoutesFetcher) Routes(search models.ServiceSearch) (models.Routes, error) {
+	if err, ok := f.errs[search.Datacenter]; ok {
+		return nil, err
+	}
+	return f.routes[search.Datacenter], nil
+}
+
+func (f datacentersRoutesFetcher) Datacenters() ([]string, error) {
+	if err, ok := f.errs[models.AllDatacenters]; ok {
+		return nil, err
+	}
+	return f.datacenters, nil
+}
+
+var _ = Describe("Datacenters", func() {
+	var servers []*ghttp.Server
+	var routesFetcher datacentersRoutesFetcher
+
+	fetch := func(datacenter string) (map[string]*dto.MetricFamily, error) {
+		c := defaultConfig()
+		f := fetchers.NewMetricsFetcher(scrapers.NewScraper(clients.NewBackendFactory(c), c), routesFetcher, c)
+		return f.Metrics(context.Background(), models.ServiceSearch{Name: "app", Datacenter: datacenter}, scrapeDefaults, false, http.Header{})
+	}
+	datacenters := func(metricFamily *dto.MetricFamily) []string {
+		dcs := make([]string, 0)
+		for _, metric := range metricFamily.Metric {
+			dcs = append(dcs, labelValue(metric, "datacenter"))
+		}
+		return dcs
+	}
+
+	BeforeEach(func() {
+		routesFetcher = datacentersRoutesFetcher{
+			datacenters: []string{"dc1", "dc2", "dc3"},
+			routes:      make(map[string]models.Routes),
+			errs:        map[string]error{"dc2": errors.New("dc2 unreachable")},
+		}
+		for i, dc := range []string{"dc1", "dc3"} {
+			server, route := newInstance([]string{"app-1", "app-3"}[i], "foo 1\n")
+			route.Datacenter = dc
+			servers = append(servers, server)
+			routesFetcher.routes[dc] = models.Routes{route}
+		}
+	})
+
+	AfterEach(func() {
+		for _, server := range servers {
+			server.Close()
+		}
+		servers = nil
+	})
+
+	It("merges routes of reachable datacenters and reports unreachable ones", func() {
+		for _, datacenter := range []string{"dc1,dc2,dc3", models.AllDatacenters} {
+			metricsGroup, err := fetch(datacenter)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(datacenters(metricsGroup["foo"])).To(ConsistOf("dc1", "dc3"))
+
+			Expect(metricsGroup).To(HaveKey("promconsulfetcher_datacenter_error"))
+			Expect(metricsGroup["promconsulfetcher_datacenter_error"].Metric).To(HaveLen(1))
+			dcError := metricsGroup["promconsulfetcher_datacenter_error"].Metric[0]
+			Expect(labelValue(dcError, "datacenter")).To(Equal("dc2"))
+			Expect(labelValue(dcError, "error")).To(ContainSubstring("dc2 unreachable"))
+		}
+	})
+
+	It("reports datacenters errors when no datacenter can be reached", func() {
+		routesFetcher.errs["dc1"] = errors.New("dc1 unreachable")
+		metricsGroup, err := fetch("dc1,dc2")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(metricsGroup).ToNot(HaveKey("foo"))
+		Expect(datacenters(metricsGroup["promconsulfetcher_datacenter_error"])).To(ConsistOf("dc1", "dc2"))
+	})
+
+	It("fails when datacenters can't be listed", func() {
+		routesFetcher.errs[models.AllDatacenters] = errors.New("catalog unreachable")
+		_, err := fetch(models.AllDatacenters)
+		Expect(err).To(MatchError(ContainSubstring("catalog unreachable")))
+	})
+})
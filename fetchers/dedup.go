@@ -0,0 +1,101 @@
+package fetchers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/orange-cloudfoundry/promconsulfetcher/metrics"
+	"github.com/orange-cloudfoundry/promconsulfetcher/models"
+)
+
+// requestsDedup coalesces identical requests in flight to share a single scrape of all instances,
+// results can also be kept during a ttl to be given to next identical requests.
+type requestsDedup struct {
+	resultTTL time.Duration
+	mu        sync.Mutex
+	calls     map[string]*dedupCall
+}
+
+type dedupCall struct {
+	done   chan struct{}
+	result map[string]*dto.MetricFamily
+	err    error
+}
+
+func newRequestsDedup(resultTTL time.Duration) *requestsDedup {
+	return &requestsDedup{
+		resultTTL: resultTTL,
+		calls:     make(map[string]*dedupCall),
+	}
+}
+
+// do runs fn only if no identical call is in flight (or cached), otherwise it waits for result of this call.
+// Result is shared between callers and must not be modified.
+func (d *requestsDedup) do(ctx context.Context, key string, fn func() (map[string]*dto.MetricFamily, error)) (map[string]*dto.MetricFamily, error) {
+	d.mu.Lock()
+	call, ok := d.calls[key]
+	if ok {
+		d.mu.Unlock()
+		metrics.DeduplicatedRequestsTotal.Inc()
+		select {
+		case <-call.done:
+			return call.result, call.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	call = &dedupCall{done: make(chan struct{})}
+	d.calls[key] = call
+	d.mu.Unlock()
+
+	call.result, call.err = fn()
+	close(call.done)
+
+	// errors are not kept to not serve them longer than needed
+	if d.resultTTL <= 0 || call.err != nil {
+		d.forget(key)
+	} else {
+		time.AfterFunc(d.resultTTL, func() {
+			d.forget(key)
+		})
+	}
+	return call.result, call.err
+}
+
+func (d *requestsDedup) forget(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.calls, key)
+}
+
+// dedupKey gives a key identifying a request, headers (which contain authentication) are part of it
+// to never share results between requests with different credentials. Key is hashed to not keep credentials in memory.
+func dedupKey(serviceSearch models.ServiceSearch, scrapeDefaults models.ScrapeConfig, onlyAppMetrics bool, headers http.Header) string {
+	headerNames := make([]string, 0, len(headers))
+	for name := range headers {
+		headerNames = append(headerNames, name)
+	}
+	sort.Strings(headerNames)
+
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "%#v\n", serviceSearch)
+	fmt.Fprintf(b, "%s\n%s\n%d\n%s\n%s\n%s\n",
+		scrapeDefaults.Scheme, scrapeDefaults.MetricPath, scrapeDefaults.MetricsPort,
+		scrapeDefaults.Timeout, scrapeDefaults.Params.Encode(), scrapeDefaults.AddressPolicy.String(),
+	)
+	fmt.Fprintf(b, "%t\n", onlyAppMetrics)
+	for _, name := range headerNames {
+		fmt.Fprintf(b, "%s: %q\n", name, headers[name])
+	}
+	sum := sha256.Sum256([]byte(b.String()))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,128 @@
+package fetchers_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/onsi/gomega/ghttp"
+	dto "github.com/prometheus/client_model/go"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/orange-cloudfoundry/promconsulfetcher/config"
+	"github.com/orange-cloudfoundry/promconsulfetcher/fetchers"
+	"github.com/orange-cloudfoundry/promconsulfetcher/metrics"
+	"github.com/orange-cloudfoundry/promconsulfetcher/models"
+)
+
+var _ = Describe("Dedup", func() {
+	var server *ghttp.Server
+	var route *models.Route
+
+	dedupConfig := func(resultTTL string) config.Config {
+		c := defaultConfig()
+		Expect(c.Initialize([]byte("scrape_dedup:\n  enabled: true\n  result_ttl: " + resultTTL + "\n"))).To(Succeed())
+		return c
+	}
+	fetch := func(f *fetchers.MetricsFetcher, search models.ServiceSearch, defaults models.ScrapeConfig, onlyAppMetrics bool, headers http.Header) map[string]*dto.MetricFamily {
+		metricsGroup, err := f.Metrics(context.Background(), search, defaults, onlyAppMetrics, headers)
+		Expect(err).ToNot(HaveOccurred())
+		return metricsGroup
+	}
+	search := models.ServiceSearch{Name: "app"}
+
+	BeforeEach(func() {
+		server, route = newInstance("app-1", "foo 1\n")
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("shares result of identical requests during result ttl", func() {
+		f := newMetricsFetcher(dedupConfig("1h"), route)
+		deduplicated := counterValue(metrics.DeduplicatedRequestsTotal)
+		first := fetch(f, search, scrapeDefaults, false, http.Header{"Authorization": {"Basic Zm9vOmJhcg=="}})
+		second := fetch(f, search, scrapeDefaults, false, http.Header{"Authorization": {"Basic Zm9vOmJhcg=="}})
+		Expect(server.ReceivedRequests()).To(HaveLen(1))
+		Expect(second).To(HaveKey("foo"))
+		Expect(second["foo"]).To(BeIdenticalTo(first["foo"]))
+		Expect(counterValue(metrics.DeduplicatedRequestsTotal) - deduplicated).To(Equal(1.0))
+	})
+
+	It("does not share result between different requests", func() {
+		f := newMetricsFetcher(dedupConfig("1h"), route)
+		fetch(f, search, scrapeDefaults, false, http.Header{})
+		Expect(server.ReceivedRequests()).To(HaveLen(1))
+
+		fetch(f, models.ServiceSearch{Name: "app", Tag: "tag"}, scrapeDefaults, false, http.Header{})
+		Expect(server.ReceivedRequests()).To(HaveLen(2))
+
+		withParams := scrapeDefaults
+		withParams.Params = url.Values{"module": {"foo"}}
+		fetch(f, search, withParams, false, http.Header{})
+		Expect(server.ReceivedRequests()).To(HaveLen(3))
+
+		fetch(f, search, scrapeDefaults, true, http.Header{})
+		Expect(server.ReceivedRequests()).To(HaveLen(4))
+
+		fetch(f, search, scrapeDefaults, false, http.Header{"Authorization": {"Basic Zm9vOmJhcg=="}})
+		Expect(server.ReceivedRequests()).To(HaveLen(5))
+		fetch(f, search, scrapeDefaults, false, http.Header{"Authorization": {"Basic Zm9vOmJheg=="}})
+		Expect(server.ReceivedRequests()).To(HaveLen(6))
+	})
+
+	It("scrapes again when result ttl has expired", func() {
+		f := newMetricsFetcher(dedupConfig("50ms"), route)
+		fetch(f, search, scrapeDefaults, false, http.Header{})
+		fetch(f, search, scrapeDefaults, false, http.Header{})
+		Expect(server.ReceivedRequests()).To(HaveLen(1))
+		Eventually(func() int {
+			fetch(f, search, scrapeDefaults, false, http.Header{})
+			return len(server.ReceivedRequests())
+		}, time.Second, 20*time.Millisecond).Should(Equal(2))
+	})
+
+	It("does not keep result without result ttl", func() {
+		f := newMetricsFetcher(dedupConfig("0s"), route)
+		fetch(f, search, scrapeDefaults, false, http.Header{})
+		fetch(f, search, scrapeDefaults, false, http.Header{})
+		Expect(server.ReceivedRequests()).To(HaveLen(2))
+	})
+
+	It("shares a scrape in flight between concurrent identical requests", func() {
+		const requests = 5
+		release := make(chan struct{})
+		server.RouteToHandler(http.MethodGet, "/metrics", func(w http.ResponseWriter, req *http.Request) {
+			<-release
+			w.Write([]byte("foo 1\n"))
+		})
+		f := newMetricsFetcher(dedupConfig("0s"), route)
+		deduplicated := counterValue(metrics.DeduplicatedRequestsTotal)
+
+		results := make([]map[string]*dto.MetricFamily, requests)
+		wg := &sync.WaitGroup{}
+		wg.Add(requests)
+		for i := 0; i < requests; i++ {
+			go func(i int) {
+				defer GinkgoRecover()
+				defer wg.Done()
+				results[i] = fetch(f, search, scrapeDefaults, false, http.Header{})
+			}(i)
+		}
+		Eventually(func() float64 {
+			return counterValue(metrics.DeduplicatedRequestsTotal) - deduplicated
+		}).Should(Equal(float64(requests - 1)))
+		close(release)
+		wg.Wait()
+
+		Expect(server.ReceivedRequests()).To(HaveLen(1))
+		for _, result := range results {
+			Expect(result["foo"]).To(BeIdenticalTo(results[0]["foo"]))
+		}
+	})
+})
@@ -0,0 +1,241 @@
+package fetchers_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/orange-cloudfoundry/promconsulfetcher/clients"
+	"github.com/orange-cloudfoundry/promconsulfetcher/config"
+	"github.com/orange-cloudfoundry/promconsulfetcher/fetchers"
+	"github.com/orange-cloudfoundry/promconsulfetcher/models"
+	"github.com/orange-cloudfoundry/promconsulfetcher/scrapers"
+)
+
+func TestFetchers(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Fetchers Suite")
+}
+
+var scrapeDefaults = models.ScrapeConfig{Scheme: "http", MetricPath: "/metrics"}
+
+type fakeRoutesFetcher struct {
+	routes models.Routes
+}
+
+func (f fakeRoutesFetcher) Routes(search models.ServiceSearch) (models.Routes, error) {
+	return f.routes, nil
+}
+
+func (f fakeRoutesFetcher) Datacenters() ([]string, error) {
+	return []string{}, nil
+}
+
+// newInstance starts a server giving content on /metrics and gives route of service app to scrape it
+func newInstance(serviceID string, content string) (*ghttp.Server, *models.Route) {
+	server := ghttp.NewServer()
+	server.RouteToHandler(http.MethodGet, "/metrics", ghttp.RespondWith(http.StatusOK, content))
+	serverURL, err := url.Parse(server.URL())
+	Expect(err).ToNot(HaveOccurred())
+	host, portStr, err := net.SplitHostPort(serverURL.Host)
+	Expect(err).ToNot(HaveOccurred())
+	port, err := strconv.Atoi(portStr)
+	Expect(err).ToNot(HaveOccurred())
+	return server, &models.Route{
+		Node:           "node",
+		ServiceName:    "app",
+		ServiceID:      serviceID,
+		ServiceAddress: host,
+		ServicePort:    port,
+	}
+}
+
+// fakeConsul serves catalog and health of services as consul does, blocking queries wait for index to change
+type fakeConsul struct {
+	*ghttp.Server
+	mu      sync.Mutex
+	index   uint64
+	entries map[string][]*api.ServiceEntry
+	failing bool
+	// hold makes non blocking queries wait until it is closed
+	hold chan struct{}
+	// waitIndexes are index parameters of queries received, empty when not given
+	waitIndexes []string
+}
+
+func newFakeConsul() *fakeConsul {
+	c := &fakeConsul{
+		Server:  ghttp.NewServer(),
+		index:   1,
+		entries: make(map[string][]*api.ServiceEntry),
+	}
+	c.RouteToHandler(http.MethodGet, regexp.MustCompile(`^/v1/catalog/service/`), c.handle(func(entries []*api.ServiceEntry) interface{} {
+		services := make([]*api.CatalogService, 0, len(entries))
+		for _, entry := range entries {
+			services = append(services, &api.CatalogService{
+				Node:           entry.Node.Node,
+				Address:        entry.Node.Address,
+				Datacenter:     entry.Node.Datacenter,
+				ServiceID:      entry.Service.ID,
+				ServiceName:    entry.Service.Service,
+				ServiceAddress: entry.Service.Address,
+				ServicePort:    entry.Service.Port,
+				ServiceTags:    entry.Service.Tags,
+			})
+		}
+		return services
+	}))
+	c.RouteToHandler(http.MethodGet, regexp.MustCompile(`^/v1/health/service/`), c.handle(func(entries []*api.ServiceEntry) interface{} {
+		return entries
+	}))
+	return c
+}
+
+func (c *fakeConsul) handle(toResponse func(entries []*api.ServiceEntry) interface{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		waitIndex := req.URL.Query().Get("index")
+		c.mu.Lock()
+		c.waitIndexes = append(c.waitIndexes, waitIndex)
+		hold := c.hold
+		c.mu.Unlock()
+		if waitIndex == "" && hold != nil {
+			<-hold
+		}
+		for start := time.Now(); waitIndex != "" && time.Since(start) < 50*time.Millisecond; time.Sleep(5 * time.Millisecond) {
+			c.mu.Lock()
+			changed := waitIndex != strconv.FormatUint(c.index, 10)
+			c.mu.Unlock()
+			if changed {
+				break
+			}
+		}
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.failing {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		entries := make([]*api.ServiceEntry, 0)
+		for _, entry := range c.entries[path.Base(req.URL.Path)] {
+			if req.URL.Query().Has("passing") && entry.Checks.AggregatedStatus() != api.HealthPassing {
+				continue
+			}
+			entries = append(entries, entry)
+		}
+		w.Header().Set("X-Consul-Index", strconv.FormatUint(c.index, 10))
+		w.Header().Set("Content-Type", "application/json")
+		Expect(json.NewEncoder(w).Encode(toResponse(entries))).To(Succeed())
+	}
+}
+
+// setEntries sets instances of service and makes index change
+func (c *fakeConsul) setEntries(serviceName string, entries ...*api.ServiceEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[serviceName] = entries
+	c.index++
+}
+
+func (c *fakeConsul) setIndex(index uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.index = index
+}
+
+func (c *fakeConsul) setFailing(failing bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failing = failing
+}
+
+func (c *fakeConsul) setHold(hold chan struct{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.hold = hold
+}
+
+func (c *fakeConsul) receivedWaitIndexes() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string{}, c.waitIndexes...)
+}
+
+func (c *fakeConsul) routesFetcher(health string) *fetchers.RoutesFetcher {
+	routesFetcher, err := fetchers.NewRoutesFetcher(config.ConsulConfig{
+		Address: strings.TrimPrefix(c.URL(), "http://"),
+		Scheme:  "http",
+		Health:  health,
+	})
+	Expect(err).ToNot(HaveOccurred())
+	return routesFetcher
+}
+
+func serviceEntry(serviceName, serviceID string, checksStatus ...string) *api.ServiceEntry {
+	entry := &api.ServiceEntry{
+		Node:    &api.Node{Node: "node", Address: "10.0.0.1", Datacenter: "dc1"},
+		Service: &api.AgentService{ID: serviceID, Service: serviceName, Address: "10.0.0.1", Port: 8080},
+	}
+	for i, status := range checksStatus {
+		entry.Checks = append(entry.Checks, &api.HealthCheck{Name: fmt.Sprintf("check-%d", i), Status: status})
+	}
+	return entry
+}
+
+func newMetricsFetcher(c config.Config, routes ...*models.Route) *fetchers.MetricsFetcher {
+	scraper := scrapers.NewScraper(clients.NewBackendFactory(c), c)
+	return fetchers.NewMetricsFetcher(scraper, fakeRoutesFetcher{routes: routes}, c)
+}
+
+func defaultConfig() config.Config {
+	c, err := config.DefaultConfig()
+	Expect(err).ToNot(HaveOccurred())
+	return *c
+}
+
+func fetchMetrics(f *fetchers.MetricsFetcher) map[string]*dto.MetricFamily {
+	metricsGroup, err := f.Metrics(context.Background(), models.ServiceSearch{Name: "app"}, scrapeDefaults, false, http.Header{})
+	Expect(err).ToNot(HaveOccurred())
+	return metricsGroup
+}
+
+func labelValue(metric *dto.Metric, name string) string {
+	for _, label := range metric.Label {
+		if label.GetName() == name {
+			return label.GetValue()
+		}
+	}
+	return ""
+}
+
+func hasLabel(metric *dto.Metric, name string) bool {
+	for _, label := range metric.Label {
+		if label.GetName() == name {
+			return true
+		}
+	}
+	return false
+}
+
+func counterValue(counter prometheus.Counter) float64 {
+	var metric dto.Metric
+	Expect(counter.Write(&metric)).To(Succeed())
+	return metric.GetCounter().GetValue()
+}
@@ -0,0 +1,48 @@
+package fetchers
+
+import (
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/orange-cloudfoundry/promconsulfetcher/models"
+)
+
+// mergeLabels adds injected labels to app labels, conflict strategy is used when app has a label with the same name.
+// Label pairs are shared between metrics so they are never modified.
+func mergeLabels(appLabels, injected []*dto.LabelPair, conflict string) []*dto.LabelPair {
+	injectedNames := make(map[string]bool, len(injected))
+	for _, label := range injected {
+		injectedNames[label.GetName()] = true
+	}
+	appNames := make(map[string]bool, len(appLabels))
+	for _, label := range appLabels {
+		appNames[label.GetName()] = true
+	}
+
+	merged := make([]*dto.LabelPair, 0, len(appLabels)+len(injected))
+	for _, label := range appLabels {
+		if !injectedNames[label.GetName()] {
+			merged = append(merged, label)
+			continue
+		}
+		switch conflict {
+		case models.LabelConflictKeep:
+			merged = append(merged, label)
+		case models.LabelConflictRename:
+			name := models.ExportedLabelPrefix + label.GetName()
+			for appNames[name] || injectedNames[name] {
+				name = models.ExportedLabelPrefix + name
+			}
+			merged = append(merged, &dto.LabelPair{
+				Name:  ptrString(name),
+				Value: label.Value,
+			})
+		}
+	}
+	for _, label := range injected {
+		if conflict == models.LabelConflictKeep && appNames[label.GetName()] {
+			continue
+		}
+		merged = append(merged, label)
+	}
+	return merged
+}
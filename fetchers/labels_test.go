@@ -0,0 +1,80 @@
+package fetchers_test
+
+import (
+	"github.com/onsi/gomega/ghttp"
+	dto "github.com/prometheus/client_model/go"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/orange-cloudfoundry/promconsulfetcher/models"
+)
+
+var _ = Describe("Labels", func() {
+	var server *ghttp.Server
+	var route *models.Route
+
+	merge := func(conflict string) *dto.Metric {
+		c := defaultConfig()
+		c.RouteLabels.Conflict = conflict
+		metricsGroup := fetchMetrics(newMetricsFetcher(c, route))
+		Expect(metricsGroup["foo"].Metric).To(HaveLen(1))
+		return metricsGroup["foo"].Metric[0]
+	}
+
+	BeforeEach(func() {
+		server, route = newInstance("app-1", "foo{service_id=\"mine\",exported_service_id=\"exported\",other=\"value\"} 1\n")
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("overwrites app labels by route labels by default", func() {
+		for _, conflict := range []string{"", models.LabelConflictOverwrite} {
+			metric := merge(conflict)
+			Expect(labelValue(metric, "service_id")).To(Equal("app-1"))
+			Expect(labelValue(metric, "exported_service_id")).To(Equal("exported"))
+			Expect(labelValue(metric, "other")).To(Equal("value"))
+			Expect(hasLabel(metric, "exported_exported_service_id")).To(BeFalse())
+		}
+	})
+
+	It("keeps app labels and does not inject route labels in conflict", func() {
+		metric := merge(models.LabelConflictKeep)
+		Expect(labelValue(metric, "service_id")).To(Equal("mine"))
+		Expect(labelValue(metric, "service_name")).To(Equal("app"))
+		Expect(labelValue(metric, "other")).To(Equal("value"))
+	})
+
+	It("injects checks labels only when included and checks are known", func() {
+		withChecks := func(include ...string) *dto.Metric {
+			c := defaultConfig()
+			c.RouteLabels.Include = include
+			metricsGroup := fetchMetrics(newMetricsFetcher(c, route))
+			Expect(metricsGroup["foo"].Metric).To(HaveLen(1))
+			return metricsGroup["foo"].Metric[0]
+		}
+		metric := withChecks("check_status", "check_names")
+		Expect(hasLabel(metric, "check_status")).To(BeFalse())
+		Expect(hasLabel(metric, "check_names")).To(BeFalse())
+
+		route.CheckStatus = "passing"
+		route.CheckNames = []string{"serfHealth", "service:app-1"}
+		metric = withChecks()
+		Expect(hasLabel(metric, "check_status")).To(BeFalse())
+		Expect(hasLabel(metric, "check_names")).To(BeFalse())
+
+		metric = withChecks("check_status", "check_names")
+		Expect(labelValue(metric, "check_status")).To(Equal("passing"))
+		Expect(labelValue(metric, "check_names")).To(Equal("serfHealth,service:app-1"))
+	})
+
+	It("renames app labels in conflict without clashing with existing labels", func() {
+		metric := merge(models.LabelConflictRename)
+		Expect(labelValue(metric, "service_id")).To(Equal("app-1"))
+		Expect(labelValue(metric, "exported_service_id")).To(Equal("exported"))
+		Expect(labelValue(metric, "exported_exported_service_id")).To(Equal("mine"))
+		Expect(labelValue(metric, "other")).To(Equal("value"))
+	})
+})
@@ -0,0 +1,137 @@
+package fetchers
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/orange-cloudfoundry/promconsulfetcher/metrics"
+	"github.com/orange-cloudfoundry/promconsulfetcher/models"
+)
+
+const (
+	sampleLimitName           = "sample_limit"
+	responseSampleLimitName   = "response_sample_limit"
+	labelLimitName            = "label_limit"
+	labelNameLengthLimitName  = "label_name_length_limit"
+	labelValueLengthLimitName = "label_value_length_limit"
+)
+
+// limitError is given when metrics of an instance exceed a limit
+type limitError struct {
+	limit   string
+	message string
+}
+
+func (e *limitError) Error() string {
+	return fmt.Sprintf("%s exceeded: %s", e.limit, e.message)
+}
+
+func newLimitError(route *models.Route, limit, format string, a ...interface{}) *limitError {
+	metrics.LimitHitsTotal.With(prometheus.Labels{
+		"service_name": route.ServiceName,
+		"limit":        limit,
+	}).Inc()
+	return &limitError{limit: limit, message: fmt.Sprintf(format, a...)}
+}
+
+// checkLimits checks that metrics scraped from an instance are in limits
+func (f MetricsFetcher) checkLimits(route *models.Route, metricsGroup map[string]*dto.MetricFamily) error {
+	samples := 0
+	for name, metricFamily := range metricsGroup {
+		samples += samplesCount(metricFamily)
+		if f.limits.SampleLimit > 0 && samples > f.limits.SampleLimit {
+			return newLimitError(route, sampleLimitName, "more than %d samples", f.limits.SampleLimit)
+		}
+		for _, metric := range metricFamily.Metric {
+			if f.limits.LabelLimit > 0 && len(metric.Label) > f.limits.LabelLimit {
+				return newLimitError(
+					route, labelLimitName, "%s has %d labels, limit is %d", name, len(metric.Label), f.limits.LabelLimit,
+				)
+			}
+			for _, label := range metric.Label {
+				if f.limits.LabelNameLengthLimit > 0 && len(label.GetName()) > f.limits.LabelNameLengthLimit {
+					return newLimitError(
+						route, labelNameLengthLimitName, "label %s of %s is longer than %d", label.GetName(), name, f.limits.LabelNameLengthLimit,
+					)
+				}
+				if f.limits.LabelValueLengthLimit > 0 && len(label.GetValue()) > f.limits.LabelValueLengthLimit {
+					return newLimitError(
+						route, labelValueLengthLimitName, "value of label %s of %s is longer than %d", label.GetName(), name, f.limits.LabelValueLengthLimit,
+					)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// applyResponseSampleLimit replaces metrics of instances which make response exceed sample limit by an error series,
+// scraped must be in order of instances as given by scrapeAll to always keep the same instances.
+func (f MetricsFetcher) applyResponseSampleLimit(scraped []scrapedMetrics) {
+	total := 0
+	for i := range scraped {
+		total = f.limitResponseSamples(&scraped[i], total)
+	}
+}
+
+// limitResponseSamples replaces metrics of instance by an error series if they make total samples of response
+// exceed sample limit, it gives total samples with those of instance when kept.
+func (f MetricsFetcher) limitResponseSamples(s *scrapedMetrics, total int) int {
+	if f.limits.ResponseSampleLimit <= 0 || s.route == nil || s.samples == 0 {
+		return total
+	}
+	if total+s.samples <= f.limits.ResponseSampleLimit {
+		return total + s.samples
+	}
+	err := newLimitError(s.route, responseSampleLimitName, "more than %d samples in response", f.limits.ResponseSampleLimit)
+	log.Warnf("Metrics of instance %s for service name %s dropped: %s", s.route.ServiceAddress, s.route.ServiceName, err.Error())
+	s.metrics = f.scrapeError(s.route, err)
+	s.samples = 0
+	if up, ok := s.report["up"]; ok {
+		for _, metric := range up.Metric {
+			metric.Gauge = &dto.Gauge{Value: ptrFloat64(0)}
+		}
+	}
+	return total
+}
+
+// totalSamples gives number of samples of all families
+func totalSamples(metricsGroup map[string]*dto.MetricFamily) int {
+	samples := 0
+	for _, metricFamily := range metricsGroup {
+		samples += samplesCount(metricFamily)
+	}
+	return samples
+}
+
+// samplesCount gives number of samples of a family as exposed in text format
+func samplesCount(metricFamily *dto.MetricFamily) int {
+	samples := 0
+	for _, metric := range metricFamily.Metric {
+		switch {
+		case metric.Histogram != nil:
+			samples += len(metric.Histogram.Bucket) + 2
+			if !hasInfBucket(metric.Histogram) {
+				samples++
+			}
+		case metric.Summary != nil:
+			samples += len(metric.Summary.Quantile) + 2
+		default:
+			samples++
+		}
+	}
+	return samples
+}
+
+func hasInfBucket(histogram *dto.Histogram) bool {
+	for _, bucket := range histogram.Bucket {
+		if math.IsInf(bucket.GetUpperBound(), +1) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,117 @@
+package fetchers_test
+
+import (
+	"github.com/onsi/gomega/ghttp"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/orange-cloudfoundry/promconsulfetcher/config"
+	"github.com/orange-cloudfoundry/promconsulfetcher/metrics"
+	"github.com/orange-cloudfoundry/promconsulfetcher/models"
+)
+
+var _ = Describe("Limits", func() {
+	const content = "# TYPE foo gauge\nfoo{a=\"1\",long_label_name=\"long label value\"} 1\nfoo{a=\"2\"} 2\n"
+	var servers []*ghttp.Server
+	var routes []*models.Route
+
+	startInstances := func(serviceIDs ...string) {
+		for _, serviceID := range serviceIDs {
+			server, route := newInstance(serviceID, content)
+			servers = append(servers, server)
+			routes = append(routes, route)
+		}
+	}
+	fetch := func(limits config.LimitsConfig) map[string]*dto.MetricFamily {
+		c := defaultConfig()
+		c.Limits = limits
+		c.RouteLabels.Exclude = models.RouteLabelNames
+		return fetchMetrics(newMetricsFetcher(c, routes...))
+	}
+	limitHits := func(limit string) prometheus.Counter {
+		return metrics.LimitHitsTotal.With(prometheus.Labels{"service_name": "app", "limit": limit})
+	}
+	expectRejected := func(metricsGroup map[string]*dto.MetricFamily, limit string) {
+		Expect(metricsGroup).ToNot(HaveKey("foo"))
+		scrapeError := metricsGroup["promconsulfetcher_scrape_error"]
+		Expect(scrapeError).ToNot(BeNil())
+		Expect(scrapeError.Metric).To(HaveLen(1))
+		Expect(labelValue(scrapeError.Metric[0], "error")).To(HavePrefix(limit + " exceeded: "))
+		Expect(metricsGroup["up"].Metric[0].GetGauge().GetValue()).To(Equal(0.0))
+	}
+
+	AfterEach(func() {
+		for _, server := range servers {
+			server.Close()
+		}
+		servers = nil
+		routes = nil
+	})
+
+	It("keeps metrics of instance in limits", func() {
+		startInstances("app-1")
+
+		metricsGroup := fetch(config.LimitsConfig{
+			SampleLimit:           2,
+			LabelLimit:            2,
+			LabelNameLengthLimit:  15,
+			LabelValueLengthLimit: 16,
+			ResponseSampleLimit:   2,
+		})
+
+		Expect(metricsGroup["foo"].Metric).To(HaveLen(2))
+		Expect(metricsGroup).ToNot(HaveKey("promconsulfetcher_scrape_error"))
+		Expect(metricsGroup["up"].Metric[0].GetGauge().GetValue()).To(Equal(1.0))
+	})
+
+	for _, entry := range []struct {
+		limit  string
+		limits config.LimitsConfig
+	}{
+		{"sample_limit", config.LimitsConfig{SampleLimit: 1}},
+		{"label_limit", config.LimitsConfig{LabelLimit: 1}},
+		{"label_name_length_limit", config.LimitsConfig{LabelNameLengthLimit: 14}},
+		{"label_value_length_limit", config.LimitsConfig{LabelValueLengthLimit: 15}},
+	} {
+		entry := entry
+		It("rejects metrics of instance exceeding "+entry.limit, func() {
+			startInstances("app-1")
+			before := counterValue(limitHits(entry.limit))
+
+			expectRejected(fetch(entry.limits), entry.limit)
+			Expect(counterValue(limitHits(entry.limit))).To(Equal(before + 1))
+		})
+	}
+
+	Context("response sample limit", func() {
+		It("replaces metrics of instances exceeding limit by an error series", func() {
+			startInstances("app-1", "app-2", "app-3")
+			c := defaultConfig()
+			c.Limits.ResponseSampleLimit = 5
+			before := counterValue(limitHits("response_sample_limit"))
+
+			metricsGroup := fetchMetrics(newMetricsFetcher(c, routes...))
+
+			foo := metricsGroup["foo"]
+			Expect(foo.Metric).To(HaveLen(4))
+			for _, metric := range foo.Metric {
+				Expect(labelValue(metric, "service_id")).To(BeElementOf("app-1", "app-2"))
+			}
+			scrapeError := metricsGroup["promconsulfetcher_scrape_error"]
+			Expect(scrapeError.Metric).To(HaveLen(1))
+			Expect(labelValue(scrapeError.Metric[0], "service_id")).To(Equal("app-3"))
+			Expect(labelValue(scrapeError.Metric[0], "error")).To(HavePrefix("response_sample_limit exceeded: "))
+			for _, metric := range metricsGroup["up"].Metric {
+				expected := 1.0
+				if labelValue(metric, "service_id") == "app-3" {
+					expected = 0
+				}
+				Expect(metric.GetGauge().GetValue()).To(Equal(expected))
+			}
+			Expect(counterValue(limitHits("response_sample_limit"))).To(Equal(before + 1))
+		})
+	})
+})
@@ -0,0 +1,204 @@
+package fetchers
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/orange-cloudfoundry/promconsulfetcher/metrics"
+	"github.com/orange-cloudfoundry/promconsulfetcher/models"
+)
+
+const familyConflictName = "promconsulfetcher_metric_family_conflict"
+
+// scrapedMetrics are metrics given for a route with the report of its scrape,
+// route is nil for metrics not related to an instance
+type scrapedMetrics struct {
+	route   *models.Route
+	metrics map[string]*dto.MetricFamily
+	report  map[string]*dto.MetricFamily
+	// samples is the number of samples scraped from instance
+	samples int
+}
+
+// familySet is a set of metric families where families of instances are added one after another
+type familySet interface {
+	// get gives family with name in set, only its name, help and type are used, nil if there is none
+	get(name string) *dto.MetricFamily
+	// add adds family with name to set or its metrics to family already in set
+	add(name string, metricFamily *dto.MetricFamily)
+}
+
+// familyMap is a familySet keeping families in memory
+type familyMap map[string]*dto.MetricFamily
+
+func (m familyMap) get(name string) *dto.MetricFamily {
+	return m[name]
+}
+
+func (m familyMap) add(name string, metricFamily *dto.MetricFamily) {
+	if existing, ok := m[name]; ok {
+		existing.Metric = append(existing.Metric, metricFamily.Metric...)
+		return
+	}
+	m[name] = metricFamily
+}
+
+// mergeMetrics merges metrics from all instances in one family per name,
+// families with the same name but a different type or help are resolved as set in configuration
+// and reported with a warning series.
+func (f MetricsFetcher) mergeMetrics(scraped []scrapedMetrics) map[string]*dto.MetricFamily {
+	base := make(familyMap)
+	warnings := make([]*dto.Metric, 0)
+	for _, s := range scraped {
+		for _, metricsGroup := range []map[string]*dto.MetricFamily{s.metrics, s.report} {
+			for name, metricFamily := range metricsGroup {
+				if warning := f.mergeFamily(base, s.route, name, metricFamily); warning != nil {
+					warnings = append(warnings, warning)
+				}
+			}
+		}
+	}
+	if len(warnings) > 0 {
+		base[familyConflictName] = familyConflictFamily(warnings)
+	}
+	return base
+}
+
+// mergeFamily adds family of an instance to set, a family in conflict with the one in set is resolved
+// as set in configuration and a warning series is given, nil if there is no conflict.
+func (f MetricsFetcher) mergeFamily(set familySet, route *models.Route, name string, metricFamily *dto.MetricFamily) *dto.Metric {
+	resolution := f.familyConflict
+	if resolution == "" {
+		resolution = models.FamilyConflictKeepFirst
+	}
+	baseMetricFamily := set.get(name)
+	if baseMetricFamily == nil {
+		set.add(name, metricFamily)
+		return nil
+	}
+	conflict := familyConflict(baseMetricFamily, metricFamily)
+	if conflict == "" {
+		set.add(name, metricFamily)
+		return nil
+	}
+	warning := f.familyConflictWarning(route, name, conflict, resolution)
+
+	switch {
+	case resolution == models.FamilyConflictDrop:
+	case resolution == models.FamilyConflictRename && conflict == models.ConflictType:
+		newName := name + "_" + strings.ToLower(metricFamily.GetType().String())
+		renamed := &dto.MetricFamily{
+			Name:   ptrString(newName),
+			Help:   metricFamily.Help,
+			Type:   metricFamily.Type,
+			Unit:   metricFamily.Unit,
+			Metric: metricFamily.Metric,
+		}
+		renamedFamily := set.get(newName)
+		if renamedFamily == nil || familyConflict(renamedFamily, renamed) != models.ConflictType {
+			set.add(newName, renamed)
+		}
+	default:
+		set.add(name, &dto.MetricFamily{
+			Name:   baseMetricFamily.Name,
+			Help:   baseMetricFamily.Help,
+			Type:   baseMetricFamily.Type,
+			Unit:   baseMetricFamily.Unit,
+			Metric: convertMetrics(metricFamily.Metric, baseMetricFamily.GetType()),
+		})
+	}
+	return warning
+}
+
+func familyConflictFamily(warnings []*dto.Metric) *dto.MetricFamily {
+	metricType := dto.MetricType_GAUGE
+	return &dto.MetricFamily{
+		Name:   ptrString(familyConflictName),
+		Help:   ptrString("Metric family of instance is in conflict with the same family from another instance."),
+		Type:   &metricType,
+		Metric: warnings,
+	}
+}
+
+// familyConflict gives kind of conflict between two families with same name, empty if there is none
+func familyConflict(a, b *dto.MetricFamily) string {
+	if a.GetType() != b.GetType() {
+		return models.ConflictType
+	}
+	if a.GetHelp() != b.GetHelp() {
+		return models.ConflictHelp
+	}
+	return ""
+}
+
+func (f MetricsFetcher) familyConflictWarning(route *models.Route, family, conflict string, resolution models.FamilyConflict) *dto.Metric {
+	labels := prometheus.Labels{}
+	serviceName := ""
+	if route != nil {
+		labels = f.instanceLabels(route)
+		serviceName = route.ServiceName
+	}
+	labels["family"] = family
+	labels["conflict"] = conflict
+	labels["resolution"] = string(resolution)
+	metrics.MetricFamilyConflictsTotal.With(prometheus.Labels{
+		"service_name": serviceName,
+		"family":       family,
+		"conflict":     conflict,
+		"resolution":   string(resolution),
+	}).Inc()
+	log.WithField("family", family).
+		WithField("conflict", conflict).
+		WithField("resolution", resolution).
+		Warnf("Metric family %s from service %s is in conflict with another instance", family, serviceName)
+
+	metric := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        familyConflictName,
+		Help:        "Metric family of instance is in conflict with the same family from another instance.",
+		ConstLabels: labels,
+	})
+	metric.Set(1)
+	var dtoMetric dto.Metric
+	metric.Write(&dtoMetric)
+	return &dtoMetric
+}
+
+// convertMetrics converts metrics to given type, metrics which can not be converted are dropped.
+// Counter, gauge and untyped are interchangeable as histogram and gauge histogram are.
+func convertMetrics(metricsList []*dto.Metric, metricType dto.MetricType) []*dto.Metric {
+	converted := make([]*dto.Metric, 0, len(metricsList))
+	for _, metric := range metricsList {
+		var value *float64
+		switch {
+		case metric.Counter != nil:
+			value = metric.Counter.Value
+		case metric.Gauge != nil:
+			value = metric.Gauge.Value
+		case metric.Untyped != nil:
+			value = metric.Untyped.Value
+		}
+		newMetric := &dto.Metric{
+			Label:       metric.Label,
+			TimestampMs: metric.TimestampMs,
+		}
+		switch {
+		case metricType == dto.MetricType_COUNTER && value != nil:
+			newMetric.Counter = &dto.Counter{Value: value}
+		case metricType == dto.MetricType_GAUGE && value != nil:
+			newMetric.Gauge = &dto.Gauge{Value: value}
+		case metricType == dto.MetricType_UNTYPED && value != nil:
+			newMetric.Untyped = &dto.Untyped{Value: value}
+		case (metricType == dto.MetricType_HISTOGRAM || metricType == dto.MetricType_GAUGE_HISTOGRAM) && metric.Histogram != nil:
+			newMetric.Histogram = metric.Histogram
+		case metricType == dto.MetricType_SUMMARY && metric.Summary != nil:
+			newMetric.Summary = metric.Summary
+		default:
+			continue
+		}
+		converted = append(converted, newMetric)
+	}
+	return converted
+}
@@ -0,0 +1,166 @@
+package fetchers_test
+
+import (
+	"github.com/onsi/gomega/ghttp"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/orange-cloudfoundry/promconsulfetcher/metrics"
+	"github.com/orange-cloudfoundry/promconsulfetcher/models"
+)
+
+var _ = Describe("Merge", func() {
+	const first = "# HELP foo first help\n# TYPE foo counter\nfoo 1\n"
+	var servers []*ghttp.Server
+	var routes []*models.Route
+
+	startInstances := func(contents ...string) {
+		for i, content := range contents {
+			server, route := newInstance([]string{"app-1", "app-2"}[i], content)
+			servers = append(servers, server)
+			routes = append(routes, route)
+		}
+	}
+	merge := func(resolution models.FamilyConflict) map[string]*dto.MetricFamily {
+		c := defaultConfig()
+		c.MetricFamilyConflict = resolution
+		return fetchMetrics(newMetricsFetcher(c, routes...))
+	}
+	conflictsTotal := func(conflict string, resolution models.FamilyConflict) prometheus.Counter {
+		return metrics.MetricFamilyConflictsTotal.With(prometheus.Labels{
+			"service_name": "app",
+			"family":       "foo",
+			"conflict":     conflict,
+			"resolution":   string(resolution),
+		})
+	}
+	expectWarning := func(metricsGroup map[string]*dto.MetricFamily, conflict string, resolution models.FamilyConflict) {
+		warning, ok := metricsGroup["promconsulfetcher_metric_family_conflict"]
+		Expect(ok).To(BeTrue())
+		Expect(warning.GetType()).To(Equal(dto.MetricType_GAUGE))
+		Expect(warning.Metric).To(HaveLen(1))
+		Expect(warning.Metric[0].GetGauge().GetValue()).To(Equal(1.0))
+		Expect(labelValue(warning.Metric[0], "service_id")).To(Equal("app-2"))
+		Expect(labelValue(warning.Metric[0], "family")).To(Equal("foo"))
+		Expect(labelValue(warning.Metric[0], "conflict")).To(Equal(conflict))
+		Expect(labelValue(warning.Metric[0], "resolution")).To(Equal(string(resolution)))
+	}
+
+	AfterEach(func() {
+		for _, server := range servers {
+			server.Close()
+		}
+		servers = nil
+		routes = nil
+	})
+
+	It("merges families without conflict and gives no warning", func() {
+		startInstances(first, first)
+
+		metricsGroup := merge("")
+
+		Expect(metricsGroup["foo"].Metric).To(HaveLen(2))
+		Expect(metricsGroup).ToNot(HaveKey("promconsulfetcher_metric_family_conflict"))
+	})
+
+	Context("with a type conflict", func() {
+		BeforeEach(func() {
+			startInstances(first, "# HELP foo first help\n# TYPE foo gauge\nfoo 2\n")
+		})
+
+		It("keeps first type and converts samples with keep_first", func() {
+			before := counterValue(conflictsTotal(models.ConflictType, models.FamilyConflictKeepFirst))
+
+			metricsGroup := merge(models.FamilyConflictKeepFirst)
+
+			foo := metricsGroup["foo"]
+			Expect(foo.GetType()).To(Equal(dto.MetricType_COUNTER))
+			Expect(foo.Metric).To(HaveLen(2))
+			Expect(labelValue(foo.Metric[1], "service_id")).To(Equal("app-2"))
+			Expect(foo.Metric[1].GetCounter().GetValue()).To(Equal(2.0))
+			Expect(metricsGroup).ToNot(HaveKey("foo_gauge"))
+			expectWarning(metricsGroup, models.ConflictType, models.FamilyConflictKeepFirst)
+			Expect(counterValue(conflictsTotal(models.ConflictType, models.FamilyConflictKeepFirst))).To(Equal(before + 1))
+		})
+
+		It("uses keep_first when resolution is not set", func() {
+			metricsGroup := merge("")
+
+			Expect(metricsGroup["foo"].Metric).To(HaveLen(2))
+			expectWarning(metricsGroup, models.ConflictType, models.FamilyConflictKeepFirst)
+		})
+
+		It("drops family of instance in conflict with drop", func() {
+			before := counterValue(conflictsTotal(models.ConflictType, models.FamilyConflictDrop))
+
+			metricsGroup := merge(models.FamilyConflictDrop)
+
+			foo := metricsGroup["foo"]
+			Expect(foo.GetType()).To(Equal(dto.MetricType_COUNTER))
+			Expect(foo.Metric).To(HaveLen(1))
+			Expect(labelValue(foo.Metric[0], "service_id")).To(Equal("app-1"))
+			expectWarning(metricsGroup, models.ConflictType, models.FamilyConflictDrop)
+			Expect(counterValue(conflictsTotal(models.ConflictType, models.FamilyConflictDrop))).To(Equal(before + 1))
+		})
+
+		It("renames family of instance in conflict with rename", func() {
+			before := counterValue(conflictsTotal(models.ConflictType, models.FamilyConflictRename))
+
+			metricsGroup := merge(models.FamilyConflictRename)
+
+			foo := metricsGroup["foo"]
+			Expect(foo.GetType()).To(Equal(dto.MetricType_COUNTER))
+			Expect(foo.Metric).To(HaveLen(1))
+			Expect(labelValue(foo.Metric[0], "service_id")).To(Equal("app-1"))
+			renamed := metricsGroup["foo_gauge"]
+			Expect(renamed).ToNot(BeNil())
+			Expect(renamed.GetName()).To(Equal("foo_gauge"))
+			Expect(renamed.GetType()).To(Equal(dto.MetricType_GAUGE))
+			Expect(renamed.Metric).To(HaveLen(1))
+			Expect(labelValue(renamed.Metric[0], "service_id")).To(Equal("app-2"))
+			expectWarning(metricsGroup, models.ConflictType, models.FamilyConflictRename)
+			Expect(counterValue(conflictsTotal(models.ConflictType, models.FamilyConflictRename))).To(Equal(before + 1))
+		})
+	})
+
+	Context("with a help conflict", func() {
+		BeforeEach(func() {
+			startInstances(first, "# HELP foo second help\n# TYPE foo counter\nfoo 2\n")
+		})
+
+		It("keeps first help and all samples with keep_first", func() {
+			metricsGroup := merge(models.FamilyConflictKeepFirst)
+
+			foo := metricsGroup["foo"]
+			Expect(foo.GetHelp()).To(Equal("first help"))
+			Expect(foo.Metric).To(HaveLen(2))
+			expectWarning(metricsGroup, models.ConflictHelp, models.FamilyConflictKeepFirst)
+		})
+
+		It("drops family of instance in conflict with drop", func() {
+			metricsGroup := merge(models.FamilyConflictDrop)
+
+			foo := metricsGroup["foo"]
+			Expect(foo.GetHelp()).To(Equal("first help"))
+			Expect(foo.Metric).To(HaveLen(1))
+			Expect(labelValue(foo.Metric[0], "service_id")).To(Equal("app-1"))
+			expectWarning(metricsGroup, models.ConflictHelp, models.FamilyConflictDrop)
+		})
+
+		It("resolves as keep_first with rename", func() {
+			before := counterValue(conflictsTotal(models.ConflictHelp, models.FamilyConflictRename))
+
+			metricsGroup := merge(models.FamilyConflictRename)
+
+			foo := metricsGroup["foo"]
+			Expect(foo.GetHelp()).To(Equal("first help"))
+			Expect(foo.Metric).To(HaveLen(2))
+			Expect(metricsGroup).ToNot(HaveKey("foo_counter"))
+			expectWarning(metricsGroup, models.ConflictHelp, models.FamilyConflictRename)
+			Expect(counterValue(conflictsTotal(models.ConflictHelp, models.FamilyConflictRename))).To(Equal(before + 1))
+		})
+	})
+})
@@ -1,14 +1,18 @@
 package fetchers
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	dto "github.com/prometheus/client_model/go"
-	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/orange-cloudfoundry/promconsulfetcher/config"
@@ -22,39 +26,122 @@ func ptrString(v string) *string {
 	return &v
 }
 
+func ptrFloat64(v float64) *float64 {
+	return &v
+}
+
+// countReader counts bytes read from underlying reader
+type countReader struct {
+	io.Reader
+	count int64
+}
+
+func (r *countReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.count += int64(n)
+	return n, err
+}
+
 type MetricsFetcher struct {
-	scraper           *scrapers.Scraper
-	routesFetcher     RoutesFetch
-	externalExporters config.ExternalExporters
+	scraper             *scrapers.Scraper
+	routesFetcher       RoutesFetch
+	externalExporters   config.ExternalExporters
+	scrapeTimeoutMargin time.Duration
+	scrapeConcurrency   int
+	scrapePool          *scrapePool
+	requestsDedup       *requestsDedup
+	relabelConfigs      models.RelabelConfigs
+	consulLabels        models.ConsulLabels
+	routeLabelsConfig   models.RouteLabelsConfig
+	familyConflict      models.FamilyConflict
+	duplicateSeries     models.DuplicateSeries
+	limits              config.LimitsConfig
 }
 
-func NewMetricsFetcher(scraper *scrapers.Scraper, routesFetcher RoutesFetch, externalExporters config.ExternalExporters) *MetricsFetcher {
-	return &MetricsFetcher{
-		scraper:           scraper,
-		routesFetcher:     routesFetcher,
-		externalExporters: externalExporters,
+func NewMetricsFetcher(scraper *scrapers.Scraper, routesFetcher RoutesFetch, c config.Config) *MetricsFetcher {
+	f := &MetricsFetcher{
+		scraper:             scraper,
+		routesFetcher:       routesFetcher,
+		externalExporters:   c.ExternalExporters,
+		scrapeTimeoutMargin: c.ScrapeTimeoutMargin.Duration(),
+		scrapeConcurrency:   c.ScrapeConcurrency,
+		relabelConfigs:      c.MetricRelabelConfigs,
+		consulLabels:        c.ConsulLabels,
+		routeLabelsConfig:   c.RouteLabels,
+		familyConflict:      c.MetricFamilyConflict,
+		duplicateSeries:     c.DuplicateSeries,
+		limits:              c.Limits,
+		scrapePool:          newScrapePool(c.MaxScrapesInFlight),
 	}
+	if c.ScrapeDedup.Enabled {
+		f.requestsDedup = newRequestsDedup(c.ScrapeDedup.ResultTTL.Duration())
+	}
+	return f
 }
 
-func (f MetricsFetcher) Metrics(consulQuery string, metricPathDefault, schemeDefault string, onlyAppMetrics bool, headers http.Header) (map[string]*dto.MetricFamily, error) {
-	serviceSearch, err := models.SearchToServiceSearch(consulQuery)
+// Metrics scrapes all instances found by serviceSearch and merges their metrics.
+// When scrapeDefaults has a timeout (usually the one asked by prometheus) all scrapes are stopped
+// at this timeout minus the configured margin and instances not scraped in time are reported in error.
+// Identical requests in flight are coalesced when deduplication is enabled, result is then shared and must not be modified.
+func (f MetricsFetcher) Metrics(ctx context.Context, serviceSearch models.ServiceSearch, scrapeDefaults models.ScrapeConfig, onlyAppMetrics bool, headers http.Header) (map[string]*dto.MetricFamily, error) {
+	if f.requestsDedup == nil {
+		return f.fetchMetrics(ctx, serviceSearch, scrapeDefaults, onlyAppMetrics, headers)
+	}
+	key := dedupKey(serviceSearch, scrapeDefaults, onlyAppMetrics, headers)
+	return f.requestsDedup.do(ctx, key, func() (map[string]*dto.MetricFamily, error) {
+		// scrape is shared with other requests so it must not be stopped if first requester goes away
+		return f.fetchMetrics(context.WithoutCancel(ctx), serviceSearch, scrapeDefaults, onlyAppMetrics, headers)
+	})
+}
+
+func (f MetricsFetcher) fetchMetrics(ctx context.Context, serviceSearch models.ServiceSearch, scrapeDefaults models.ScrapeConfig, onlyAppMetrics bool, headers http.Header) (map[string]*dto.MetricFamily, error) {
+	metricsUnmerged := make([]scrapedMetrics, 0)
+	err := f.scrapeAll(ctx, serviceSearch, scrapeDefaults, onlyAppMetrics, headers, func(scraped scrapedMetrics) {
+		metricsUnmerged = append(metricsUnmerged, scraped)
+	})
 	if err != nil {
-		return nil, err
+		return make(map[string]*dto.MetricFamily), err
+	}
+
+	if len(metricsUnmerged) == 0 {
+		return make(map[string]*dto.MetricFamily), nil
+	}
+
+	f.applyResponseSampleLimit(metricsUnmerged)
+	merged := f.mergeMetrics(metricsUnmerged)
+	f.sortSeries(merged)
+	return merged, nil
+}
+
+// scrapeAll scrapes all instances found by serviceSearch and gives metrics of each instance to onScraped,
+// onScraped is never called concurrently and is called in order of instances whatever order they are scraped.
+func (f MetricsFetcher) scrapeAll(ctx context.Context, serviceSearch models.ServiceSearch, scrapeDefaults models.ScrapeConfig, onlyAppMetrics bool, headers http.Header, onScraped func(scraped scrapedMetrics)) error {
+	if scrapeDefaults.Timeout > 0 {
+		timeout := scrapeDefaults.Timeout - f.scrapeTimeoutMargin
+		if timeout <= 0 {
+			timeout = scrapeDefaults.Timeout
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
 	}
-	routes, err := f.routesFetcher.Routes(serviceSearch)
+	routes, dcErrMetrics, err := f.findRoutes(serviceSearch)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	if len(routes) == 0 {
-		return make(map[string]*dto.MetricFamily), errors.ErrNoAppFound(consulQuery)
+	routes = enabledRoutes(routes)
+	if len(routes) == 0 && len(dcErrMetrics) == 0 {
+		return errors.ErrNoAppFound(serviceSearch.String())
 	}
 
-	jobs := make(chan *models.Route, len(routes))
 	errFetch := &errors.ErrFetch{}
 	wg := &sync.WaitGroup{}
 
 	muWrite := sync.Mutex{}
-	metricsUnmerged := make([]map[string]*dto.MetricFamily, 0)
+	scraped := make([]scrapedMetrics, 0, len(dcErrMetrics))
+	for _, dcErrMetric := range dcErrMetrics {
+		scraped = append(scraped, scrapedMetrics{metrics: dcErrMetric})
+	}
 
 	if !onlyAppMetrics && f.externalExporters != nil && len(f.externalExporters) > 0 {
 		for _, rte := range routes {
@@ -63,7 +150,7 @@ func (f MetricsFetcher) Metrics(consulQuery string, metricPathDefault, schemeDef
 				if err != nil {
 					err = fmt.Errorf("error when setting external exporters routes: %s", err.Error())
 					newMetrics := f.scrapeExternalExporterError(routeExternalExporter, ee, err)
-					metricsUnmerged = append(metricsUnmerged, newMetrics)
+					scraped = append(scraped, scrapedMetrics{route: routeExternalExporter, metrics: newMetrics})
 					log.WithField("external_exporter", ee.Name).
 						WithField("action", "route convert").
 						WithField("service", ee.Name).
@@ -75,18 +162,32 @@ func (f MetricsFetcher) Metrics(consulQuery string, metricPathDefault, schemeDef
 		}
 	}
 
+	order, positions := newScrapedOrder(scraped, routes, onScraped)
+	jobs := make(chan int, len(routes))
 	wg.Add(len(routes))
-	for w := 1; w <= 5; w++ {
-		go func(jobs <-chan *models.Route, errFetch *errors.ErrFetch, headers http.Header) {
-			for j := range jobs {
+	for w := 1; w <= f.scrapeConcurrency; w++ {
+		go func(jobs <-chan int, errFetch *errors.ErrFetch, headers http.Header) {
+			for i := range jobs {
+				j := routes[i]
+				jobHeaders := headers
 				if j.Node == "external_exporter" {
-					headers = nil
+					jobHeaders = nil
 				}
-				newMetrics, err := f.Metric(j, metricPathDefault, schemeDefault, headers)
+				var newMetrics map[string]*dto.MetricFamily
+				var stats scrapeStats
+				startScrape := time.Now()
+				err := f.scrapePool.acquire(ctx)
+				if err == nil {
+					startScrape = time.Now()
+					newMetrics, stats, err = f.scrapeMetric(ctx, j, scrapeDefaults, jobHeaders)
+					f.scrapePool.release()
+				}
+				scrapeDuration := time.Since(startScrape)
 				if err != nil {
 					if errF, ok := err.(*errors.ErrFetch); ok && (f.externalExporters == nil || len(f.externalExporters) == 0) {
 						muWrite.Lock()
 						*errFetch = *errF
+						order.done(positions[i], scrapedMetrics{}, true)
 						muWrite.Unlock()
 						wg.Done()
 						continue
@@ -97,133 +198,340 @@ func (f MetricsFetcher) Metrics(consulQuery string, metricPathDefault, schemeDef
 				} else {
 					metrics.MetricFetchSuccessTotal.With(metrics.RouteToLabelNoInstance(j)).Inc()
 				}
+				report := f.scrapeReport(f.routeLabels(j, scrapeDefaults), err == nil, scrapeDuration, stats)
+				samples := 0
+				if err == nil {
+					samples = totalSamples(newMetrics)
+				}
 				muWrite.Lock()
-				metricsUnmerged = append(metricsUnmerged, newMetrics)
+				order.done(positions[i], scrapedMetrics{
+					route:   j,
+					metrics: newMetrics,
+					report:  report,
+					samples: samples,
+				}, false)
 				muWrite.Unlock()
 				wg.Done()
 			}
 		}(jobs, errFetch, headers)
 	}
-	for _, route := range routes {
-		jobs <- route
+	for i := range routes {
+		jobs <- i
 	}
 	wg.Wait()
 	close(jobs)
 	if errFetch.Code != 0 {
-		return make(map[string]*dto.MetricFamily), errFetch
+		return errFetch
 	}
+	return nil
+}
 
-	if len(metricsUnmerged) == 0 {
-		return make(map[string]*dto.MetricFamily), nil
+// enabledRoutes removes routes which opted out from scraping
+func enabledRoutes(routes models.Routes) models.Routes {
+	enabled := make(models.Routes, 0, len(routes))
+	for _, route := range routes {
+		if route.IsScrapeDisabled() {
+			continue
+		}
+		enabled = append(enabled, route)
 	}
+	return enabled
+}
 
-	base := metricsUnmerged[0]
-	for _, metricKV := range metricsUnmerged[1:] {
-		for k, metricFamily := range metricKV {
-			baseMetricFamily, ok := base[k]
-			if !ok {
-				base[k] = metricFamily
-				continue
-			}
-			baseMetricFamily.Metric = append(baseMetricFamily.Metric, metricFamily.Metric...)
+// findRoutes retrieves routes for search, when search is on multiple datacenters they are all queried concurrently
+// and datacenters in error are given as error metrics instead of failing.
+func (f MetricsFetcher) findRoutes(serviceSearch models.ServiceSearch) (models.Routes, []map[string]*dto.MetricFamily, error) {
+	errMetrics := make([]map[string]*dto.MetricFamily, 0)
+	if !serviceSearch.IsMultiDatacenters() {
+		routes, err := f.routesFetcher.Routes(serviceSearch)
+		return routes, errMetrics, err
+	}
+
+	dcs := serviceSearch.Datacenters()
+	if serviceSearch.Datacenter == models.AllDatacenters {
+		var err error
+		dcs, err = f.routesFetcher.Datacenters()
+		if err != nil {
+			return nil, nil, err
 		}
 	}
-	return base, nil
+
+	var routes models.Routes
+	mu := sync.Mutex{}
+	wg := &sync.WaitGroup{}
+	wg.Add(len(dcs))
+	for _, dc := range dcs {
+		go func(dc string) {
+			defer wg.Done()
+			dcRoutes, err := f.routesFetcher.Routes(serviceSearch.WithDatacenter(dc))
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				log.WithField("datacenter", dc).Warningf("Cannot get routes for %s: %s", serviceSearch.String(), err.Error())
+				errMetrics = append(errMetrics, f.datacenterError(dc, err))
+				return
+			}
+			routes = append(routes, dcRoutes...)
+		}(dc)
+	}
+	wg.Wait()
+	return routes, errMetrics, nil
+}
+
+func (f MetricsFetcher) Metric(ctx context.Context, route *models.Route, scrapeDefaults models.ScrapeConfig, headers http.Header) (map[string]*dto.MetricFamily, error) {
+	metricsGroup, _, err := f.scrapeMetric(ctx, route, scrapeDefaults, headers)
+	return metricsGroup, err
 }
 
-func (f MetricsFetcher) Metric(route *models.Route, metricPathDefault, schemeDefault string, headers http.Header) (map[string]*dto.MetricFamily, error) {
-	reader, err := f.scraper.Scrape(route, metricPathDefault, schemeDefault, headers)
+// scrapeStats are stats about response of an instance
+type scrapeStats struct {
+	// samples is the number of samples exposed by instance, before relabeling
+	samples      int
+	responseSize int64
+}
+
+// scrapeMetric scrapes route and injects route labels on metrics, it also gives stats about response
+func (f MetricsFetcher) scrapeMetric(ctx context.Context, route *models.Route, scrapeDefaults models.ScrapeConfig, headers http.Header) (map[string]*dto.MetricFamily, scrapeStats, error) {
+	stats := scrapeStats{}
+	scrapeConfig, err := route.ScrapeConfig(scrapeDefaults)
 	if err != nil {
-		return nil, err
+		return nil, stats, err
+	}
+	resp, err := f.scraper.Scrape(ctx, route, scrapeConfig, headers)
+	if sizeErr, ok := err.(*scrapers.BodySizeLimitError); ok {
+		return nil, stats, newLimitError(route, sizeErr.Limit, "body is bigger than %d bytes", sizeErr.MaxBytes)
 	}
-	defer reader.Close()
-	parser := &expfmt.TextParser{}
-	metricsGroup, err := parser.TextToMetricFamilies(reader)
 	if err != nil {
-		return nil, err
+		return nil, stats, err
+	}
+	defer resp.Close()
+	counter := &countReader{Reader: resp}
+	metricsGroup, err := scrapers.Decode(counter, resp.ContentType)
+	stats.responseSize = counter.count
+	if sizeErr := resp.SizeLimitError(); sizeErr != nil {
+		return nil, stats, newLimitError(route, sizeErr.Limit, "body is bigger than %d bytes", sizeErr.MaxBytes)
 	}
+	if err != nil {
+		return nil, stats, err
+	}
+	stats.samples = totalSamples(metricsGroup)
 
+	labels := f.scrapeLabels(route, scrapeConfig)
+	conflict := f.routeLabelsConfig.ConflictStrategy()
 	for _, metricGroup := range metricsGroup {
 		for _, metric := range metricGroup.Metric {
-			metric.Label = f.cleanMetricLabels(
-				metric.Label,
-				"node_name", "node_id", "node_address",
-				"datacenter", "service_name", "service_id",
-				"service_address", "service_port",
-			)
-			metric.Label = append(metric.Label,
-				&dto.LabelPair{
-					Name:  ptrString("node_name"),
-					Value: ptrString(route.Node),
-				},
-				&dto.LabelPair{
-					Name:  ptrString("node_id"),
-					Value: ptrString(route.ID),
-				},
-				&dto.LabelPair{
-					Name:  ptrString("node_address"),
-					Value: ptrString(route.Address),
-				},
-				&dto.LabelPair{
-					Name:  ptrString("datacenter"),
-					Value: ptrString(route.Datacenter),
-				},
-				&dto.LabelPair{
-					Name:  ptrString("service_name"),
-					Value: ptrString(route.ServiceName),
-				},
-				&dto.LabelPair{
-					Name:  ptrString("service_id"),
-					Value: ptrString(route.ServiceID),
-				},
-				&dto.LabelPair{
-					Name:  ptrString("service_address"),
-					Value: ptrString(route.ServiceAddress),
-				},
-				&dto.LabelPair{
-					Name:  ptrString("service_port"),
-					Value: ptrString(strconv.Itoa(route.ServicePort)),
-				},
-			)
+			metric.Label = mergeLabels(metric.Label, labels, conflict)
+		}
+	}
+	relabelConfigs := make(models.RelabelConfigs, 0, len(scrapeConfig.RelabelConfigs)+len(f.relabelConfigs))
+	relabelConfigs = append(relabelConfigs, scrapeConfig.RelabelConfigs...)
+	relabelConfigs = append(relabelConfigs, f.relabelConfigs...)
+	if len(relabelConfigs) > 0 {
+		metricsGroup = relabelMetrics(metricsGroup, f.discoveryLabels(route), relabelConfigs)
+	}
+	if err := f.checkLimits(route, metricsGroup); err != nil {
+		return nil, stats, err
+	}
+	return metricsGroup, stats, nil
+}
+
+// routeLabels gives labels to use for route, scrapeDefaults are resolved to know if metrics port must be set
+func (f MetricsFetcher) routeLabels(route *models.Route, scrapeDefaults models.ScrapeConfig) []*dto.LabelPair {
+	scrapeConfig, err := route.ScrapeConfig(scrapeDefaults)
+	if err != nil {
+		scrapeConfig = scrapeDefaults
+	}
+	return f.scrapeLabels(route, scrapeConfig)
+}
 
+// scrapeLabels gives route labels and labels from consul selected in configuration,
+// labels from consul starting with `__` are only given as discovery labels for relabeling.
+func (f MetricsFetcher) scrapeLabels(route *models.Route, scrapeConfig models.ScrapeConfig) []*dto.LabelPair {
+	labels := f.namedLabels(routeLabels(route, scrapeConfig))
+	for _, label := range f.consulLabels.Labels(route) {
+		if strings.HasPrefix(label.Name, model.ReservedLabelPrefix) {
+			continue
 		}
+		labels = append(labels, &dto.LabelPair{
+			Name:  ptrString(label.Name),
+			Value: ptrString(label.Value),
+		})
 	}
-	return metricsGroup, nil
+	return labels
 }
 
-func (f MetricsFetcher) cleanMetricLabels(labels []*dto.LabelPair, names ...string) []*dto.LabelPair {
-	finalLabels := make([]*dto.LabelPair, 0)
-	for _, label := range labels {
-		toAdd := true
-		for _, name := range names {
-			if label.Name != nil && *label.Name == name {
-				toAdd = false
-				break
-			}
+// discoveryLabels gives labels usable as source labels during relabeling
+func (f MetricsFetcher) discoveryLabels(route *models.Route) map[string]string {
+	labels := route.DiscoveryLabels()
+	for _, label := range f.consulLabels.Labels(route) {
+		if strings.HasPrefix(label.Name, model.ReservedLabelPrefix) {
+			labels[label.Name] = label.Value
+		}
+	}
+	return labels
+}
+
+// routeLabels gives labels injected on all metrics from route
+func routeLabels(route *models.Route, scrapeConfig models.ScrapeConfig) []*dto.LabelPair {
+	labels := []*dto.LabelPair{
+		{
+			Name:  ptrString("node_name"),
+			Value: ptrString(route.Node),
+		},
+		{
+			Name:  ptrString("node_id"),
+			Value: ptrString(route.ID),
+		},
+		{
+			Name:  ptrString("node_address"),
+			Value: ptrString(route.Address),
+		},
+		{
+			Name:  ptrString("datacenter"),
+			Value: ptrString(route.Datacenter),
+		},
+		{
+			Name:  ptrString("service_name"),
+			Value: ptrString(route.ServiceName),
+		},
+		{
+			Name:  ptrString("service_id"),
+			Value: ptrString(route.ServiceID),
+		},
+		{
+			Name:  ptrString("service_address"),
+			Value: ptrString(route.ServiceAddress),
+		},
+		{
+			Name:  ptrString("service_port"),
+			Value: ptrString(strconv.Itoa(route.ServicePort)),
+		},
+	}
+	return append(labels, optionalRouteLabels(route, scrapeConfig)...)
+}
+
+// optionalRouteLabels gives labels which are only injected when route has a value for it
+func optionalRouteLabels(route *models.Route, scrapeConfig models.ScrapeConfig) []*dto.LabelPair {
+	metricsPort := ""
+	if scrapeConfig.MetricsPort > 0 && scrapeConfig.MetricsPort != route.ServicePort {
+		metricsPort = strconv.Itoa(scrapeConfig.MetricsPort)
+	}
+	labels := make([]*dto.LabelPair, 0)
+	for _, kv := range [][2]string{
+		{"namespace", route.Namespace},
+		{"partition", route.Partition},
+		{"failover_datacenter", route.FailoverDatacenter},
+		{"metrics_port", metricsPort},
+		{"check_status", route.CheckStatus},
+		{"check_names", strings.Join(route.CheckNames, ",")},
+	} {
+		if kv[1] == "" {
+			continue
 		}
-		if toAdd {
-			finalLabels = append(finalLabels, label)
+		labels = append(labels, &dto.LabelPair{
+			Name:  ptrString(kv[0]),
+			Value: ptrString(kv[1]),
+		})
+	}
+	return labels
+}
+
+// namedLabels renames route labels as set in configuration, excluded labels are removed
+func (f MetricsFetcher) namedLabels(labels []*dto.LabelPair) []*dto.LabelPair {
+	named := make([]*dto.LabelPair, 0, len(labels))
+	for _, label := range labels {
+		name, ok := f.routeLabelsConfig.Name(label.GetName())
+		if !ok {
+			continue
 		}
+		named = append(named, &dto.LabelPair{
+			Name:  ptrString(name),
+			Value: label.Value,
+		})
 	}
-	return finalLabels
+	return named
+}
+
+// instanceLabels gives route labels for promconsulfetcher series about an instance
+func (f MetricsFetcher) instanceLabels(route *models.Route) prometheus.Labels {
+	labels := prometheus.Labels{}
+	for _, label := range f.namedLabels(routeLabels(route, models.ScrapeConfig{})) {
+		labels[label.GetName()] = label.GetValue()
+	}
+	return labels
+}
+
+// errorLabels gives route labels for error series
+func (f MetricsFetcher) errorLabels(route *models.Route, err error) prometheus.Labels {
+	labels := f.instanceLabels(route)
+	labels["error"] = err.Error()
+	return labels
 }
 
 func (f MetricsFetcher) scrapeError(route *models.Route, err error) map[string]*dto.MetricFamily {
 	name := "promconsulfetcher_scrape_error"
 	help := "Promconsulfetcher scrap error on your instance"
+	metric := prometheus.NewCounter(prometheus.CounterOpts{
+		Name:        name,
+		Help:        help,
+		ConstLabels: f.errorLabels(route, err),
+	})
+	metric.Inc()
+	var dtoMetric dto.Metric
+	metric.Write(&dtoMetric)
+	metricType := dto.MetricType_COUNTER
+	return map[string]*dto.MetricFamily{
+		"promconsulfetcher_scrape_error": {
+			Name:   ptrString(name),
+			Help:   ptrString(help),
+			Type:   &metricType,
+			Metric: []*dto.Metric{&dtoMetric},
+		},
+	}
+}
+
+// scrapeReport gives synthetic series about scrape of an instance as prometheus does when scraping a target
+func (f MetricsFetcher) scrapeReport(labels []*dto.LabelPair, up bool, duration time.Duration, stats scrapeStats) map[string]*dto.MetricFamily {
+	upValue := 0.0
+	samples := 0
+	if up {
+		upValue = 1
+		samples = stats.samples
+	}
+	report := make(map[string]*dto.MetricFamily)
+	for _, sample := range []struct {
+		name  string
+		help  string
+		value float64
+	}{
+		{"up", "1 if the instance is healthy and reachable, 0 if the scrape failed.", upValue},
+		{"scrape_duration_seconds", "Duration of the scrape of the instance.", duration.Seconds()},
+		{"scrape_samples_scraped", "Number of samples the instance exposed.", float64(samples)},
+		{"scrape_response_size_bytes", "Uncompressed size of the instance response in bytes.", float64(stats.responseSize)},
+	} {
+		metricType := dto.MetricType_GAUGE
+		report[sample.name] = &dto.MetricFamily{
+			Name: ptrString(sample.name),
+			Help: ptrString(sample.help),
+			Type: &metricType,
+			Metric: []*dto.Metric{{
+				Label: labels,
+				Gauge: &dto.Gauge{Value: ptrFloat64(sample.value)},
+			}},
+		}
+	}
+	return report
+}
+
+func (f MetricsFetcher) datacenterError(dc string, err error) map[string]*dto.MetricFamily {
+	name := "promconsulfetcher_datacenter_error"
+	help := "Promconsulfetcher error when retrieving instances in a datacenter"
 	metric := prometheus.NewCounter(prometheus.CounterOpts{
 		Name: name,
 		Help: help,
 		ConstLabels: prometheus.Labels{
-			"node_name":       route.Node,
-			"node_id":         route.ID,
-			"node_address":    route.Address,
-			"datacenter":      route.Datacenter,
-			"service_name":    route.ServiceName,
-			"service_id":      route.ServiceID,
-			"service_address": route.ServiceAddress,
-			"service_port":    strconv.Itoa(route.ServicePort),
-			"error":           err.Error(),
+			"datacenter": dc,
+			"error":      err.Error(),
 		},
 	})
 	metric.Inc()
@@ -231,7 +539,7 @@ func (f MetricsFetcher) scrapeError(route *models.Route, err error) map[string]*
 	metric.Write(&dtoMetric)
 	metricType := dto.MetricType_COUNTER
 	return map[string]*dto.MetricFamily{
-		"promconsulfetcher_scrape_error": {
+		"promconsulfetcher_datacenter_error": {
 			Name:   ptrString(name),
 			Help:   ptrString(help),
 			Type:   &metricType,
@@ -244,19 +552,9 @@ func (f MetricsFetcher) scrapeExternalExporterError(route *models.Route, externa
 	name := "promconsulfetcher_scrape_external_exporter_error"
 	help := "Promconsulfetcher scrap external exporter error on your instance"
 	metric := prometheus.NewCounter(prometheus.CounterOpts{
-		Name: name,
-		Help: help,
-		ConstLabels: prometheus.Labels{
-			"node_name":       route.Node,
-			"node_id":         route.ID,
-			"node_address":    route.Address,
-			"datacenter":      route.Datacenter,
-			"service_name":    route.ServiceName,
-			"service_id":      route.ServiceID,
-			"service_address": route.ServiceAddress,
-			"service_port":    strconv.Itoa(route.ServicePort),
-			"error":           err.Error(),
-		},
+		Name:        name,
+		Help:        help,
+		ConstLabels: f.errorLabels(route, err),
 	})
 	metric.Inc()
 	var dtoMetric dto.Metric
@@ -0,0 +1,69 @@
+package fetchers
+
+import (
+	"sort"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+
+	"github.com/orange-cloudfoundry/promconsulfetcher/models"
+)
+
+// relabelMetrics applies relabel configs on each metric of metricsGroup,
+// discovery labels (e.g. `__meta_consul_service_metadata_<key>`) are available during relabeling.
+// As in prometheus, labels starting with `__` are removed afterwards and metrics can be renamed by setting `__name__`.
+func relabelMetrics(metricsGroup map[string]*dto.MetricFamily, discoveryLabels map[string]string, relabelConfigs models.RelabelConfigs) map[string]*dto.MetricFamily {
+	relabeled := make(map[string]*dto.MetricFamily)
+	for name, metricGroup := range metricsGroup {
+		for _, metric := range metricGroup.Metric {
+			labels := make(map[string]string, len(metric.Label)+len(discoveryLabels)+1)
+			for k, v := range discoveryLabels {
+				labels[k] = v
+			}
+			for _, label := range metric.Label {
+				labels[label.GetName()] = label.GetValue()
+			}
+			labels[model.MetricNameLabel] = name
+			if !relabelConfigs.Process(labels) {
+				continue
+			}
+			newName := labels[model.MetricNameLabel]
+			if !model.IsValidMetricName(model.LabelValue(newName)) {
+				continue
+			}
+			metric.Label = labelPairs(labels)
+
+			target, ok := relabeled[newName]
+			if !ok {
+				target = &dto.MetricFamily{
+					Name: ptrString(newName),
+					Help: metricGroup.Help,
+					Type: metricGroup.Type,
+					Unit: metricGroup.Unit,
+				}
+				relabeled[newName] = target
+			}
+			target.Metric = append(target.Metric, metric)
+		}
+	}
+	return relabeled
+}
+
+// labelPairs gives label pairs sorted by name without empty labels and labels starting with `__`
+func labelPairs(labels map[string]string) []*dto.LabelPair {
+	pairs := make([]*dto.LabelPair, 0, len(labels))
+	for k, v := range labels {
+		if v == "" || strings.HasPrefix(k, model.ReservedLabelPrefix) {
+			continue
+		}
+		pairs = append(pairs, &dto.LabelPair{
+			Name:  ptrString(k),
+			Value: ptrString(v),
+		})
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].GetName() < pairs[j].GetName()
+	})
+	return pairs
+}
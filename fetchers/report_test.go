@@ -0,0 +1,48 @@
+package fetchers_test
+
+import (
+	"github.com/onsi/gomega/ghttp"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/orange-cloudfoundry/promconsulfetcher/models"
+)
+
+var _ = Describe("Report", func() {
+	const content = `# TYPE foo counter
+foo 1
+# TYPE bar counter
+bar 2
+# TYPE baz histogram
+baz_bucket{le="1"} 1
+baz_bucket{le="+Inf"} 2
+baz_sum 3
+baz_count 2
+`
+	var server *ghttp.Server
+	var route *models.Route
+
+	BeforeEach(func() {
+		server, route = newInstance("app-1", content)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("counts samples exposed by instance before relabeling", func() {
+		c := defaultConfig()
+		Expect(c.Initialize([]byte(`
+metric_relabel_configs:
+- source_labels: [__name__]
+  regex: bar
+  action: drop
+`))).To(Succeed())
+		metricsGroup := fetchMetrics(newMetricsFetcher(c, route))
+		Expect(metricsGroup).ToNot(HaveKey("bar"))
+		Expect(metricsGroup["up"].Metric[0].GetGauge().GetValue()).To(Equal(1.0))
+		Expect(metricsGroup["scrape_samples_scraped"].Metric[0].GetGauge().GetValue()).To(Equal(6.0))
+		Expect(metricsGroup["scrape_response_size_bytes"].Metric[0].GetGauge().GetValue()).To(Equal(float64(len(content))))
+	})
+})
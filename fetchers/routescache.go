@@ -0,0 +1,201 @@
+package fetchers
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/orange-cloudfoundry/promconsulfetcher/metrics"
+	"github.com/orange-cloudfoundry/promconsulfetcher/models"
+)
+
+const (
+	routesWatchRetryWait = 5 * time.Second
+	// prepared queries do not support blocking queries, they are polled instead
+	preparedQueryPollInterval = 30 * time.Second
+)
+
+// RoutesCache is a RoutesFetch which keeps routes in memory,
+// routes are kept up to date by a consul blocking query per service search
+// and a service search is evicted when it is not requested during idle timeout.
+type RoutesCache struct {
+	routesFetcher *RoutesFetcher
+	idleTimeout   time.Duration
+	mu            sync.Mutex
+	watches       map[models.ServiceSearch]*routesWatch
+}
+
+type routesWatch struct {
+	mu         sync.RWMutex
+	ready      chan struct{}
+	err        error
+	routes     models.Routes
+	index      uint64
+	lastAccess time.Time
+	// lastContact is last time consul answered, it is only used when watch is failing
+	// as consul is considered in contact while a blocking query is pending
+	lastContact time.Time
+	failing     bool
+}
+
+func NewRoutesCache(routesFetcher *RoutesFetcher, idleTimeout time.Duration) *RoutesCache {
+	return &RoutesCache{
+		routesFetcher: routesFetcher,
+		idleTimeout:   idleTimeout,
+		watches:       make(map[models.ServiceSearch]*routesWatch),
+	}
+}
+
+func (c *RoutesCache) Routes(search models.ServiceSearch) (models.Routes, error) {
+	c.mu.Lock()
+	watch, ok := c.watches[search]
+	if !ok {
+		watch = &routesWatch{
+			ready:      make(chan struct{}),
+			lastAccess: time.Now(),
+		}
+		c.watches[search] = watch
+		c.mu.Unlock()
+		metrics.RoutesCacheMissesTotal.Inc()
+		return c.startWatch(search, watch)
+	}
+	c.mu.Unlock()
+
+	// waiting for a watch being created is a miss as routes are retrieved from consul
+	hit := true
+	select {
+	case <-watch.ready:
+	default:
+		hit = false
+		metrics.RoutesCacheMissesTotal.Inc()
+		<-watch.ready
+	}
+	if watch.err != nil {
+		return nil, watch.err
+	}
+	if hit {
+		metrics.RoutesCacheHitsTotal.Inc()
+	}
+
+	watch.mu.Lock()
+	watch.lastAccess = time.Now()
+	routes := copyRoutes(watch.routes)
+	watch.mu.Unlock()
+	c.updateStaleness(search.Name)
+	return routes, nil
+}
+
+// Datacenters is not cached as it is only a single and cheap call to consul
+func (c *RoutesCache) Datacenters() ([]string, error) {
+	return c.routesFetcher.Datacenters()
+}
+
+// startWatch does first synchronous retrieval of routes and run watch in background if it succeeded
+func (c *RoutesCache) startWatch(search models.ServiceSearch, watch *routesWatch) (models.Routes, error) {
+	defer close(watch.ready)
+	routes, index, err := c.routesFetcher.RoutesBlocking(search, 0)
+	if err != nil {
+		watch.err = err
+		c.remove(search)
+		return nil, err
+	}
+	watch.routes = routes
+	watch.index = index
+	watch.lastContact = time.Now()
+	go c.watch(search, watch)
+	c.updateStaleness(search.Name)
+	return copyRoutes(routes), nil
+}
+
+func (c *RoutesCache) watch(search models.ServiceSearch, watch *routesWatch) {
+	entry := log.WithField("search", search.String())
+	for {
+		watch.mu.RLock()
+		idle := time.Since(watch.lastAccess) > c.idleTimeout
+		index := watch.index
+		watch.mu.RUnlock()
+		if idle {
+			entry.Debug("Evicting idle routes from cache")
+			c.remove(search)
+			return
+		}
+
+		if search.PreparedQuery {
+			time.Sleep(preparedQueryPollInterval)
+		}
+		routes, newIndex, err := c.routesFetcher.RoutesBlocking(search, index)
+		if err != nil {
+			entry.Warningf("Error when watching routes, retrying in %s: %s", routesWatchRetryWait, err.Error())
+			watch.mu.Lock()
+			watch.failing = true
+			watch.mu.Unlock()
+			c.updateStaleness(search.Name)
+			time.Sleep(routesWatchRetryWait)
+			continue
+		}
+		// index went backward (e.g. consul snapshot restored) so we must reset it as consul recommend
+		if newIndex < index {
+			newIndex = 0
+		}
+
+		watch.mu.Lock()
+		watch.routes = routes
+		watch.index = newIndex
+		watch.lastContact = time.Now()
+		watch.failing = false
+		watch.mu.Unlock()
+		c.updateStaleness(search.Name)
+	}
+}
+
+func (c *RoutesCache) remove(search models.ServiceSearch) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.watches, search)
+	c.setStaleness(search.Name)
+}
+
+// staleness gives time since routes have been synced with consul, it is 0 when watch is in contact with consul
+func (w *routesWatch) staleness() time.Duration {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if !w.failing {
+		return 0
+	}
+	return time.Since(w.lastContact)
+}
+
+func (c *RoutesCache) updateStaleness(serviceName string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.setStaleness(serviceName)
+}
+
+// setStaleness sets staleness of service name to the highest one of its watches,
+// searches are not used as label to keep its cardinality bounded. c.mu must be held.
+func (c *RoutesCache) setStaleness(serviceName string) {
+	found := false
+	var staleness time.Duration
+	for search, watch := range c.watches {
+		if search.Name != serviceName {
+			continue
+		}
+		found = true
+		if s := watch.staleness(); s > staleness {
+			staleness = s
+		}
+	}
+	if !found {
+		metrics.RoutesCacheStaleness.DeleteLabelValues(serviceName)
+		return
+	}
+	metrics.RoutesCacheStaleness.WithLabelValues(serviceName).Set(staleness.Seconds())
+}
+
+// copyRoutes gives a new slice of routes to let caller append to it without altering cache
+func copyRoutes(routes models.Routes) models.Routes {
+	newRoutes := make(models.Routes, len(routes))
+	copy(newRoutes, routes)
+	return newRoutes
+}
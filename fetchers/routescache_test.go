@@ -0,0 +1,172 @@
+package fetchers_test
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/orange-cloudfoundry/promconsulfetcher/fetchers"
+	"github.com/orange-cloudfoundry/promconsulfetcher/metrics"
+	"github.com/orange-cloudfoundry/promconsulfetcher/models"
+)
+
+var _ = Describe("RoutesCache", func() {
+	var consul *fakeConsul
+
+	// staleness gives staleness of service name, false is returned when service name has no staleness
+	staleness := func(serviceName string) (float64, bool) {
+		ch := make(chan prometheus.Metric, 100)
+		metrics.RoutesCacheStaleness.Collect(ch)
+		close(ch)
+		for metric := range ch {
+			var m dto.Metric
+			Expect(metric.Write(&m)).To(Succeed())
+			if labelValue(&m, "service_name") == serviceName {
+				return m.GetGauge().GetValue(), true
+			}
+		}
+		return 0, false
+	}
+	hasStaleness := func(serviceName string) func() bool {
+		return func() bool {
+			_, ok := staleness(serviceName)
+			return ok
+		}
+	}
+	routes := func(cache *fetchers.RoutesCache, search models.ServiceSearch) models.Routes {
+		routes, err := cache.Routes(search)
+		Expect(err).ToNot(HaveOccurred())
+		return routes
+	}
+
+	BeforeEach(func() {
+		consul = newFakeConsul()
+	})
+
+	AfterEach(func() {
+		consul.Close()
+	})
+
+	It("retrieves routes from consul on miss and serves them from cache on hit", func() {
+		search := models.ServiceSearch{Name: "cache-hit"}
+		consul.setEntries(search.Name, serviceEntry(search.Name, "cache-hit-1"))
+		cache := fetchers.NewRoutesCache(consul.routesFetcher(""), time.Minute)
+		hits := counterValue(metrics.RoutesCacheHitsTotal)
+		misses := counterValue(metrics.RoutesCacheMissesTotal)
+
+		Expect(routes(cache, search)).To(HaveLen(1))
+		Expect(counterValue(metrics.RoutesCacheMissesTotal) - misses).To(Equal(1.0))
+		Expect(counterValue(metrics.RoutesCacheHitsTotal) - hits).To(Equal(0.0))
+
+		Expect(routes(cache, search)[0].ServiceID).To(Equal("cache-hit-1"))
+		Expect(counterValue(metrics.RoutesCacheMissesTotal) - misses).To(Equal(1.0))
+		Expect(counterValue(metrics.RoutesCacheHitsTotal) - hits).To(Equal(1.0))
+
+		consul.setEntries(search.Name, serviceEntry(search.Name, "cache-hit-1"), serviceEntry(search.Name, "cache-hit-2"))
+		Eventually(func() models.Routes {
+			return routes(cache, search)
+		}).Should(HaveLen(2))
+		Expect(counterValue(metrics.RoutesCacheMissesTotal) - misses).To(Equal(1.0))
+	})
+
+	It("counts callers waiting for routes being retrieved as misses", func() {
+		search := models.ServiceSearch{Name: "cache-wait"}
+		consul.setEntries(search.Name, serviceEntry(search.Name, "cache-wait-1"))
+		hold := make(chan struct{})
+		consul.setHold(hold)
+		cache := fetchers.NewRoutesCache(consul.routesFetcher(""), time.Minute)
+		hits := counterValue(metrics.RoutesCacheHitsTotal)
+		misses := counterValue(metrics.RoutesCacheMissesTotal)
+
+		results := make(chan models.Routes, 2)
+		get := func() {
+			defer GinkgoRecover()
+			results <- routes(cache, search)
+		}
+		go get()
+		Eventually(consul.receivedWaitIndexes).Should(HaveLen(1))
+		go get()
+		Eventually(func() float64 {
+			return counterValue(metrics.RoutesCacheMissesTotal) - misses
+		}).Should(Equal(2.0))
+		Consistently(results, 50*time.Millisecond).ShouldNot(Receive())
+
+		close(hold)
+		for i := 0; i < 2; i++ {
+			Eventually(results).Should(Receive(HaveLen(1)))
+		}
+		Expect(counterValue(metrics.RoutesCacheHitsTotal) - hits).To(Equal(0.0))
+		Expect(consul.receivedWaitIndexes()[0]).To(BeEmpty())
+	})
+
+	It("evicts routes not requested during idle timeout", func() {
+		search := models.ServiceSearch{Name: "cache-idle"}
+		consul.setEntries(search.Name, serviceEntry(search.Name, "cache-idle-1"))
+		cache := fetchers.NewRoutesCache(consul.routesFetcher(""), 100*time.Millisecond)
+		misses := counterValue(metrics.RoutesCacheMissesTotal)
+
+		routes(cache, search)
+		Expect(hasStaleness(search.Name)()).To(BeTrue())
+		Eventually(hasStaleness(search.Name), time.Second).Should(BeFalse())
+
+		routes(cache, search)
+		Expect(counterValue(metrics.RoutesCacheMissesTotal) - misses).To(Equal(2.0))
+	})
+
+	It("serves stale routes and reports staleness while consul can't be reached", func() {
+		search := models.ServiceSearch{Name: "cache-stale"}
+		consul.setEntries(search.Name, serviceEntry(search.Name, "cache-stale-1"))
+		cache := fetchers.NewRoutesCache(consul.routesFetcher(""), time.Minute)
+		hits := counterValue(metrics.RoutesCacheHitsTotal)
+
+		routes(cache, search)
+		value, _ := staleness(search.Name)
+		Expect(value).To(Equal(0.0))
+
+		consul.setFailing(true)
+		consul.setEntries(search.Name)
+		Eventually(func() float64 {
+			value, _ := staleness(search.Name)
+			return value
+		}).Should(BeNumerically(">", 0))
+
+		Expect(routes(cache, search)).To(HaveLen(1))
+		Expect(counterValue(metrics.RoutesCacheHitsTotal) - hits).To(Equal(1.0))
+	})
+
+	It("does not keep routes which could not be retrieved", func() {
+		search := models.ServiceSearch{Name: "cache-error"}
+		consul.setEntries(search.Name, serviceEntry(search.Name, "cache-error-1"))
+		consul.setFailing(true)
+		cache := fetchers.NewRoutesCache(consul.routesFetcher(""), time.Minute)
+		misses := counterValue(metrics.RoutesCacheMissesTotal)
+
+		_, err := cache.Routes(search)
+		Expect(err).To(HaveOccurred())
+		Expect(hasStaleness(search.Name)()).To(BeFalse())
+
+		consul.setFailing(false)
+		Expect(routes(cache, search)).To(HaveLen(1))
+		Expect(counterValue(metrics.RoutesCacheMissesTotal) - misses).To(Equal(2.0))
+	})
+
+	It("resets index when consul index goes backward", func() {
+		search := models.ServiceSearch{Name: "cache-reset"}
+		consul.setIndex(10)
+		consul.setEntries(search.Name, serviceEntry(search.Name, "cache-reset-1"))
+		cache := fetchers.NewRoutesCache(consul.routesFetcher(""), time.Minute)
+
+		routes(cache, search)
+		Eventually(consul.receivedWaitIndexes).Should(ContainElement("11"))
+		consul.setIndex(5)
+		Eventually(func() []string {
+			indexes := consul.receivedWaitIndexes()
+			return indexes[1:]
+		}).Should(ContainElement(""))
+		Eventually(consul.receivedWaitIndexes).Should(ContainElement("5"))
+	})
+})
@@ -1,6 +1,8 @@
 package fetchers
 
 import (
+	"fmt"
+	"net/http"
 	"sort"
 	"time"
 
@@ -8,6 +10,7 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/orange-cloudfoundry/promconsulfetcher/config"
+	fetchErrors "github.com/orange-cloudfoundry/promconsulfetcher/errors"
 	"github.com/orange-cloudfoundry/promconsulfetcher/models"
 )
 
@@ -15,10 +18,12 @@ import (
 
 type RoutesFetch interface {
 	Routes(search models.ServiceSearch) (models.Routes, error)
+	Datacenters() ([]string, error)
 }
 
 type RoutesFetcher struct {
-	consulClient *api.Client
+	consulClient  *api.Client
+	defaultHealth string
 }
 
 func NewRoutesFetcher(consulConfig config.ConsulConfig) (*RoutesFetcher, error) {
@@ -27,18 +32,42 @@ func NewRoutesFetcher(consulConfig config.ConsulConfig) (*RoutesFetcher, error)
 		return nil, err
 	}
 	return &RoutesFetcher{
-		consulClient: client,
+		consulClient:  client,
+		defaultHealth: consulConfig.Health,
 	}, nil
 }
 
 func (f *RoutesFetcher) Routes(search models.ServiceSearch) (models.Routes, error) {
+	routes, _, err := f.RoutesBlocking(search, 0)
+	return routes, err
+}
 
-	entries, _, err := f.consulClient.Catalog().Service(search.Name, search.Tag, &api.QueryOptions{
-		Datacenter: search.Datacenter,
-		Near:       search.Near,
-	})
+func (f *RoutesFetcher) Datacenters() ([]string, error) {
+	dcs, err := f.consulClient.Catalog().Datacenters()
+	if err != nil {
+		return nil, errors.Wrap(err, "catalog.datacenters")
+	}
+	return dcs, nil
+}
+
+// RoutesBlocking retrieves routes with a consul blocking query,
+// call will wait for a change after waitIndex (no wait when waitIndex is 0)
+// and returns the consul index to use on next call.
+func (f *RoutesFetcher) RoutesBlocking(search models.ServiceSearch, waitIndex uint64) (models.Routes, uint64, error) {
+	if search.PreparedQuery {
+		return f.preparedQueryRoutes(search)
+	}
+	health := search.Health
+	if health == "" {
+		health = f.defaultHealth
+	}
+	if health != "" {
+		return f.healthRoutes(search, health, waitIndex)
+	}
+
+	entries, meta, err := f.consulClient.Catalog().Service(search.Name, search.Tag, f.queryOptions(search, waitIndex))
 	if err != nil {
-		return nil, errors.Wrap(err, search.String())
+		return nil, 0, consulError(err, search)
 	}
 
 	var list models.Routes
@@ -56,9 +85,106 @@ func (f *RoutesFetcher) Routes(search models.ServiceSearch) (models.Routes, erro
 			ServiceTags:     deepCopyAndSortTags(s.ServiceTags),
 			ServiceMeta:     s.ServiceMeta,
 			ServicePort:     s.ServicePort,
+			Namespace:       s.Namespace,
+			Partition:       s.Partition,
 		})
 	}
-	return list, nil
+	return list, meta.LastIndex, nil
+}
+
+// healthRoutes retrieves routes from health endpoint and only keep instances matching the given health
+func (f *RoutesFetcher) healthRoutes(search models.ServiceSearch, health string, waitIndex uint64) (models.Routes, uint64, error) {
+	entries, meta, err := f.consulClient.Health().Service(search.Name, search.Tag, health == models.HealthPassing, f.queryOptions(search, waitIndex))
+	if err != nil {
+		return nil, 0, consulError(err, search)
+	}
+
+	var list models.Routes
+	for _, s := range entries {
+		if !matchHealth(s.Checks.AggregatedStatus(), health) {
+			continue
+		}
+		list = append(list, serviceEntryToRoute(s))
+	}
+	return list, meta.LastIndex, nil
+}
+
+// preparedQueryRoutes executes a prepared query, health filtering is made by the prepared query itself
+func (f *RoutesFetcher) preparedQueryRoutes(search models.ServiceSearch) (models.Routes, uint64, error) {
+	resp, meta, err := f.consulClient.PreparedQuery().Execute(search.Name, f.queryOptions(search, 0))
+	if err != nil {
+		return nil, 0, consulError(err, search)
+	}
+
+	var list models.Routes
+	for i := range resp.Nodes {
+		route := serviceEntryToRoute(&resp.Nodes[i])
+		if resp.Failovers > 0 {
+			route.FailoverDatacenter = resp.Datacenter
+		}
+		list = append(list, route)
+	}
+	return list, meta.LastIndex, nil
+}
+
+// consulError gives a bad request error when consul refused filter from search
+func consulError(err error, search models.ServiceSearch) error {
+	if statusErr, ok := err.(api.StatusError); ok && statusErr.Code == http.StatusBadRequest && search.Filter != "" {
+		return fetchErrors.ErrBadRequest(fmt.Sprintf("consul refused filter %q: %s", search.Filter, statusErr.Body))
+	}
+	return errors.Wrap(err, search.String())
+}
+
+func serviceEntryToRoute(s *api.ServiceEntry) *models.Route {
+	return &models.Route{
+		ID:              s.Node.ID,
+		Node:            s.Node.Node,
+		Address:         s.Node.Address,
+		Datacenter:      s.Node.Datacenter,
+		TaggedAddresses: s.Node.TaggedAddresses,
+		NodeMeta:        s.Node.Meta,
+		ServiceID:       s.Service.ID,
+		ServiceName:     s.Service.Service,
+		ServiceAddress:  s.Service.Address,
+		ServiceTags:     deepCopyAndSortTags(s.Service.Tags),
+		ServiceMeta:     s.Service.Meta,
+		ServicePort:     s.Service.Port,
+		Namespace:       s.Service.Namespace,
+		Partition:       s.Service.Partition,
+		CheckStatus:     s.Checks.AggregatedStatus(),
+		CheckNames:      checkNames(s.Checks),
+	}
+}
+
+func (f *RoutesFetcher) queryOptions(search models.ServiceSearch, waitIndex uint64) *api.QueryOptions {
+	return &api.QueryOptions{
+		Datacenter: search.Datacenter,
+		Near:       search.Near,
+		Namespace:  search.Namespace,
+		Partition:  search.Partition,
+		Filter:     search.Filter,
+		WaitIndex:  waitIndex,
+	}
+}
+
+func matchHealth(status, health string) bool {
+	switch health {
+	case models.HealthPassing:
+		return status == api.HealthPassing
+	case models.HealthWarning:
+		return status == api.HealthPassing || status == api.HealthWarning
+	}
+	return true
+}
+
+// checkNames returns sorted names of the given checks
+func checkNames(checks api.HealthChecks) []string {
+	names := make([]string, 0, len(checks))
+	for _, check := range checks {
+		names = append(names, check.Name)
+	}
+	sort.Strings(names)
+	return names
 }
 
 func createClient(cfg config.ConsulConfig) (*api.Client, error) {
@@ -68,6 +194,8 @@ func createClient(cfg config.ConsulConfig) (*api.Client, error) {
 		Datacenter: cfg.DataCenter,
 		WaitTime:   time.Duration(cfg.EndpointWaitTime),
 		Token:      cfg.Token,
+		Namespace:  cfg.Namespace,
+		Partition:  cfg.Partition,
 	}
 
 	if cfg.HTTPAuth != nil {
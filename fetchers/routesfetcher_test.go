@@ -0,0 +1,76 @@
+package fetchers_test
+
+import (
+	"github.com/hashicorp/consul/api"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/orange-cloudfoundry/promconsulfetcher/models"
+)
+
+var _ = Describe("RoutesFetcher", func() {
+	var consul *fakeConsul
+
+	serviceIDs := func(routes models.Routes) []string {
+		ids := make([]string, 0, len(routes))
+		for _, route := range routes {
+			ids = append(ids, route.ServiceID)
+		}
+		return ids
+	}
+
+	BeforeEach(func() {
+		consul = newFakeConsul()
+		consul.setEntries("app",
+			serviceEntry("app", "app-passing", api.HealthPassing, api.HealthPassing),
+			serviceEntry("app", "app-warning", api.HealthPassing, api.HealthWarning),
+			serviceEntry("app", "app-critical", api.HealthWarning, api.HealthCritical),
+		)
+	})
+
+	AfterEach(func() {
+		consul.Close()
+	})
+
+	It("keeps instances matching health with their checks", func() {
+		for _, tc := range []struct {
+			health string
+			ids    []string
+		}{
+			{models.HealthPassing, []string{"app-passing"}},
+			{models.HealthWarning, []string{"app-passing", "app-warning"}},
+			{models.HealthAny, []string{"app-passing", "app-warning", "app-critical"}},
+		} {
+			routes, err := consul.routesFetcher("").Routes(models.ServiceSearch{Name: "app", Health: tc.health})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(serviceIDs(routes)).To(ConsistOf(tc.ids), "health %s", tc.health)
+		}
+
+		routes, err := consul.routesFetcher("").Routes(models.ServiceSearch{Name: "app", Health: models.HealthAny})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(routes[1].CheckStatus).To(Equal(api.HealthWarning))
+		Expect(routes[1].CheckNames).To(Equal([]string{"check-0", "check-1"}))
+		Expect(routes[2].CheckStatus).To(Equal(api.HealthCritical))
+	})
+
+	It("uses health from configuration when search does not give one", func() {
+		routes, err := consul.routesFetcher(models.HealthWarning).Routes(models.ServiceSearch{Name: "app"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(serviceIDs(routes)).To(ConsistOf("app-passing", "app-warning"))
+
+		routes, err = consul.routesFetcher(models.HealthWarning).Routes(models.ServiceSearch{Name: "app", Health: models.HealthPassing})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(serviceIDs(routes)).To(ConsistOf("app-passing"))
+	})
+
+	It("retrieves instances from catalog without checks when no health is given", func() {
+		routes, err := consul.routesFetcher("").Routes(models.ServiceSearch{Name: "app"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(serviceIDs(routes)).To(ConsistOf("app-passing", "app-warning", "app-critical"))
+		for _, route := range routes {
+			Expect(route.CheckStatus).To(BeEmpty())
+			Expect(route.CheckNames).To(BeEmpty())
+		}
+	})
+})
@@ -0,0 +1,51 @@
+package fetchers
+
+import (
+	"context"
+	"time"
+
+	"github.com/orange-cloudfoundry/promconsulfetcher/metrics"
+)
+
+// scrapePool limits number of instances scrapes in flight for the whole process,
+// a pool with size 0 has no limit.
+type scrapePool struct {
+	slots chan struct{}
+}
+
+func newScrapePool(size int) *scrapePool {
+	if size <= 0 {
+		return &scrapePool{}
+	}
+	return &scrapePool{
+		slots: make(chan struct{}, size),
+	}
+}
+
+// acquire waits for a free slot, it gives an error if context is done before
+func (p *scrapePool) acquire(ctx context.Context) error {
+	if p.slots == nil {
+		metrics.ScrapesInFlight.Inc()
+		return nil
+	}
+	metrics.ScrapesQueued.Inc()
+	start := time.Now()
+	defer func() {
+		metrics.ScrapesQueued.Dec()
+		metrics.ScrapeQueueWaitSeconds.Observe(time.Since(start).Seconds())
+	}()
+	select {
+	case p.slots <- struct{}{}:
+		metrics.ScrapesInFlight.Inc()
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *scrapePool) release() {
+	metrics.ScrapesInFlight.Dec()
+	if p.slots != nil {
+		<-p.slots
+	}
+}
@@ -0,0 +1,200 @@
+package fetchers
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/orange-cloudfoundry/promconsulfetcher/metrics"
+	"github.com/orange-cloudfoundry/promconsulfetcher/models"
+)
+
+// scrapedOrder gives scraped metrics to onScraped sorted by instance to always resolve them in the same order,
+// metrics not related to an instance come first. Metrics of an instance are kept until those of all
+// instances before it have been given.
+type scrapedOrder struct {
+	onScraped func(scraped scrapedMetrics)
+	slots     []scrapedSlot
+	next      int
+}
+
+type scrapedSlot struct {
+	scraped scrapedMetrics
+	done    bool
+	// skipped is set when instance has no metrics to give
+	skipped bool
+}
+
+// newScrapedOrder creates an order for metrics already scraped and for routes to scrape,
+// positions gives position of each route to set its metrics with done.
+func newScrapedOrder(scraped []scrapedMetrics, routes models.Routes, onScraped func(scraped scrapedMetrics)) (*scrapedOrder, []int) {
+	slots := make([]scrapedSlot, 0, len(scraped)+len(routes))
+	for _, s := range scraped {
+		slots = append(slots, scrapedSlot{scraped: s, done: true})
+	}
+	for _, route := range routes {
+		slots = append(slots, scrapedSlot{scraped: scrapedMetrics{route: route}})
+	}
+	routeIndexes := make([]int, len(slots))
+	for i := range routeIndexes {
+		routeIndexes[i] = i - len(scraped)
+	}
+	sort.Stable(scrapedSlots{slots: slots, routeIndexes: routeIndexes})
+
+	positions := make([]int, len(routes))
+	for position, routeIndex := range routeIndexes {
+		if routeIndex >= 0 {
+			positions[routeIndex] = position
+		}
+	}
+	o := &scrapedOrder{onScraped: onScraped, slots: slots}
+	o.flush()
+	return o, positions
+}
+
+// done sets metrics scraped at position, skipped metrics are not given to onScraped
+func (o *scrapedOrder) done(position int, scraped scrapedMetrics, skipped bool) {
+	o.slots[position] = scrapedSlot{scraped: scraped, done: true, skipped: skipped}
+	o.flush()
+}
+
+func (o *scrapedOrder) flush() {
+	for o.next < len(o.slots) && o.slots[o.next].done {
+		if !o.slots[o.next].skipped {
+			o.onScraped(o.slots[o.next].scraped)
+		}
+		// metrics given are not kept
+		o.slots[o.next] = scrapedSlot{done: true}
+		o.next++
+	}
+}
+
+// scrapedSlots sorts slots by instance along with index of their route
+type scrapedSlots struct {
+	slots        []scrapedSlot
+	routeIndexes []int
+}
+
+func (s scrapedSlots) Len() int {
+	return len(s.slots)
+}
+
+func (s scrapedSlots) Less(i, j int) bool {
+	return routeSortKey(s.slots[i].scraped.route) < routeSortKey(s.slots[j].scraped.route)
+}
+
+func (s scrapedSlots) Swap(i, j int) {
+	s.slots[i], s.slots[j] = s.slots[j], s.slots[i]
+	s.routeIndexes[i], s.routeIndexes[j] = s.routeIndexes[j], s.routeIndexes[i]
+}
+
+func routeSortKey(route *models.Route) string {
+	if route == nil {
+		return ""
+	}
+	return strings.Join([]string{
+		route.ServiceName,
+		route.Datacenter,
+		route.Node,
+		route.ServiceID,
+		route.ServiceAddress,
+		strconv.Itoa(route.ServicePort),
+	}, "\xff")
+}
+
+// sortSeries sorts labels and series of each family by labels, series with the same labels are resolved
+// as set in configuration by dropping them or adding a `duplicate` label with their index
+// (prefixed by `_` when series already has a label with this name).
+func (f MetricsFetcher) sortSeries(metricsGroup map[string]*dto.MetricFamily) {
+	for name, metricFamily := range metricsGroup {
+		keys := make(map[*dto.Metric]string, len(metricFamily.Metric))
+		for _, metric := range metricFamily.Metric {
+			sortLabels(metric.Label)
+			keys[metric] = seriesKey(metric.Label)
+		}
+		sort.SliceStable(metricFamily.Metric, func(i, j int) bool {
+			return keys[metricFamily.Metric[i]] < keys[metricFamily.Metric[j]]
+		})
+
+		series := make([]*dto.Metric, 0, len(metricFamily.Metric))
+		duplicates := 0
+		for i, metric := range metricFamily.Metric {
+			if i == 0 || keys[metric] != keys[metricFamily.Metric[i-1]] {
+				duplicates = 0
+				series = append(series, metric)
+				continue
+			}
+			duplicates++
+			if f.resolveDuplicate(name, metric, keys[metric], duplicates) {
+				series = append(series, metric)
+			}
+		}
+		metricFamily.Metric = series
+	}
+}
+
+// resolveDuplicate resolves series which is the duplicate with given index of another series as set in configuration,
+// false is returned when series must be dropped. Labels of series must be sorted.
+func (f MetricsFetcher) resolveDuplicate(name string, metric *dto.Metric, key string, index int) bool {
+	resolution := f.duplicateSeries
+	if resolution == "" {
+		resolution = models.DuplicateSeriesDisambiguate
+	}
+	metrics.DuplicateSeriesTotal.With(prometheus.Labels{
+		"family":     name,
+		"resolution": string(resolution),
+	}).Inc()
+	log.WithField("family", name).
+		WithField("resolution", resolution).
+		Warnf("Duplicate series %s{%s}", name, key)
+	if resolution == models.DuplicateSeriesDrop {
+		return false
+	}
+	labels := make([]*dto.LabelPair, 0, len(metric.Label)+1)
+	labels = append(labels, metric.Label...)
+	labels = append(labels, &dto.LabelPair{
+		Name:  ptrString(duplicateLabelName(metric.Label)),
+		Value: ptrString(strconv.Itoa(index)),
+	})
+	sortLabels(labels)
+	metric.Label = labels
+	return true
+}
+
+// duplicateLabelName gives name of label to add on a duplicate series,
+// it is prefixed by `_` until it does not clash with a label of series.
+func duplicateLabelName(labels []*dto.LabelPair) string {
+	name := models.DuplicateLabel
+	for {
+		clash := false
+		for _, label := range labels {
+			if label.GetName() == name {
+				clash = true
+				break
+			}
+		}
+		if !clash {
+			return name
+		}
+		name = "_" + name
+	}
+}
+
+func sortLabels(labels []*dto.LabelPair) {
+	sort.SliceStable(labels, func(i, j int) bool {
+		return labels[i].GetName() < labels[j].GetName()
+	})
+}
+
+// seriesKey gives a key identifying series from its sorted labels
+func seriesKey(labels []*dto.LabelPair) string {
+	pairs := make([]string, len(labels))
+	for i, label := range labels {
+		pairs[i] = label.GetName() + "=" + strconv.Quote(label.GetValue())
+	}
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,89 @@
+package fetchers_test
+
+import (
+	"github.com/onsi/gomega/ghttp"
+	dto "github.com/prometheus/client_model/go"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/orange-cloudfoundry/promconsulfetcher/models"
+)
+
+var _ = Describe("Series", func() {
+	var servers []*ghttp.Server
+	var routes []*models.Route
+
+	// instances give the same series and no route label is injected so series are duplicated after merge
+	startInstances := func(content string) {
+		for _, serviceID := range []string{"app-1", "app-2", "app-3"} {
+			server, route := newInstance(serviceID, content)
+			servers = append(servers, server)
+			routes = append(routes, route)
+		}
+	}
+	fetch := func(resolution models.DuplicateSeries) map[string]*dto.MetricFamily {
+		c := defaultConfig()
+		c.DuplicateSeries = resolution
+		c.RouteLabels.Exclude = models.RouteLabelNames
+		return fetchMetrics(newMetricsFetcher(c, routes...))
+	}
+
+	AfterEach(func() {
+		for _, server := range servers {
+			server.Close()
+		}
+		servers = nil
+		routes = nil
+	})
+
+	It("sorts series by labels", func() {
+		startInstances("foo{a=\"2\"} 1\nfoo{a=\"1\"} 1\n")
+
+		foo := fetch(models.DuplicateSeriesDrop)["foo"]
+
+		Expect(foo.Metric).To(HaveLen(2))
+		Expect(labelValue(foo.Metric[0], "a")).To(Equal("1"))
+		Expect(labelValue(foo.Metric[1], "a")).To(Equal("2"))
+	})
+
+	It("adds a duplicate label with index of duplicate with disambiguate", func() {
+		startInstances("foo{a=\"1\"} 1\n")
+
+		foo := fetch(models.DuplicateSeriesDisambiguate)["foo"]
+
+		Expect(foo.Metric).To(HaveLen(3))
+		Expect(hasLabel(foo.Metric[0], models.DuplicateLabel)).To(BeFalse())
+		Expect(labelValue(foo.Metric[1], models.DuplicateLabel)).To(Equal("1"))
+		Expect(labelValue(foo.Metric[2], models.DuplicateLabel)).To(Equal("2"))
+	})
+
+	It("uses disambiguate when resolution is not set", func() {
+		startInstances("foo{a=\"1\"} 1\n")
+
+		Expect(fetch("")["foo"].Metric).To(HaveLen(3))
+	})
+
+	It("does not overwrite a duplicate label given by app with disambiguate", func() {
+		startInstances("foo{duplicate=\"app\"} 1\n")
+
+		foo := fetch(models.DuplicateSeriesDisambiguate)["foo"]
+
+		Expect(foo.Metric).To(HaveLen(3))
+		for _, metric := range foo.Metric {
+			Expect(labelValue(metric, models.DuplicateLabel)).To(Equal("app"))
+		}
+		Expect(hasLabel(foo.Metric[0], "_duplicate")).To(BeFalse())
+		Expect(labelValue(foo.Metric[1], "_duplicate")).To(Equal("1"))
+		Expect(labelValue(foo.Metric[2], "_duplicate")).To(Equal("2"))
+	})
+
+	It("only keeps first series with drop", func() {
+		startInstances("foo{a=\"1\"} 1\n")
+
+		foo := fetch(models.DuplicateSeriesDrop)["foo"]
+
+		Expect(foo.Metric).To(HaveLen(1))
+		Expect(hasLabel(foo.Metric[0], models.DuplicateLabel)).To(BeFalse())
+	})
+})
@@ -0,0 +1,219 @@
+package fetchers
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"sort"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/orange-cloudfoundry/promconsulfetcher/models"
+)
+
+// StreamableFormat checks that metrics can be streamed in format,
+// text, openmetrics and delimited protobuf are streamable.
+func StreamableFormat(format expfmt.Format) bool {
+	switch format.FormatType() {
+	case expfmt.TypeTextPlain, expfmt.TypeOpenMetrics, expfmt.TypeProtoDelim:
+		return true
+	}
+	return false
+}
+
+// StreamMetrics scrapes all instances found by serviceSearch as Metrics does but encodes metrics of each instance
+// in format in order of instances instead of keeping them all in memory to merge them.
+// Encoded families are written to w sorted by name once all instances have reported, nothing is written
+// when an error is returned. Family conflicts, duplicate series and response sample limit are resolved
+// in order of instances as Metrics does. Requests are not deduplicated.
+func (f MetricsFetcher) StreamMetrics(ctx context.Context, serviceSearch models.ServiceSearch, scrapeDefaults models.ScrapeConfig, onlyAppMetrics bool, headers http.Header, w io.Writer, format expfmt.Format) error {
+	encoder := f.newStreamEncoder(format)
+	err := f.scrapeAll(ctx, serviceSearch, scrapeDefaults, onlyAppMetrics, headers, encoder.addScraped)
+	if err != nil {
+		return err
+	}
+	return encoder.writeTo(w)
+}
+
+// streamEncoder is a familySet which encodes families as they are added, only name, help and type of families
+// and a hash of their series are kept to resolve conflicts and duplicate series.
+type streamEncoder struct {
+	f        MetricsFetcher
+	format   expfmt.Format
+	families map[string]*streamFamily
+	warnings []*dto.Metric
+	// samples is the number of samples of instances for response sample limit
+	samples int
+	// scratch is reused between encodings to limit allocations
+	scratch bytes.Buffer
+	err     error
+}
+
+type streamFamily struct {
+	// base is the family without metrics
+	base    *dto.MetricFamily
+	encoded bytes.Buffer
+	// series counts series by hash of their labels to find duplicates
+	series map[uint64]int
+}
+
+func (f MetricsFetcher) newStreamEncoder(format expfmt.Format) *streamEncoder {
+	return &streamEncoder{
+		f:        f,
+		format:   format,
+		families: make(map[string]*streamFamily),
+	}
+}
+
+// addScraped encodes metrics of an instance, it must not be called concurrently
+func (e *streamEncoder) addScraped(scraped scrapedMetrics) {
+	e.samples = e.f.limitResponseSamples(&scraped, e.samples)
+	for _, metricsGroup := range []map[string]*dto.MetricFamily{scraped.metrics, scraped.report} {
+		names := make([]string, 0, len(metricsGroup))
+		for name := range metricsGroup {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if warning := e.f.mergeFamily(e, scraped.route, name, metricsGroup[name]); warning != nil {
+				e.warnings = append(e.warnings, warning)
+			}
+		}
+	}
+}
+
+func (e *streamEncoder) get(name string) *dto.MetricFamily {
+	if family, ok := e.families[name]; ok {
+		return family.base
+	}
+	return nil
+}
+
+func (e *streamEncoder) add(name string, metricFamily *dto.MetricFamily) {
+	family, ok := e.families[name]
+	if !ok {
+		family = &streamFamily{
+			base: &dto.MetricFamily{
+				Name: ptrString(name),
+				Help: metricFamily.Help,
+				Type: metricFamily.Type,
+				Unit: metricFamily.Unit,
+			},
+			series: make(map[uint64]int),
+		}
+		e.families[name] = family
+	}
+	series := make([]*dto.Metric, 0, len(metricFamily.Metric))
+	for _, metric := range metricFamily.Metric {
+		sortLabels(metric.Label)
+		hash := seriesHash(metric.Label)
+		index := family.series[hash]
+		family.series[hash] = index + 1
+		if index > 0 && !e.f.resolveDuplicate(name, metric, seriesKey(metric.Label), index) {
+			continue
+		}
+		series = append(series, metric)
+	}
+	if len(series) == 0 || e.err != nil {
+		return
+	}
+	e.err = e.encode(family, series)
+}
+
+// encode encodes series of family, help and type are only encoded with first series of family
+func (e *streamEncoder) encode(family *streamFamily, series []*dto.Metric) error {
+	first := family.encoded.Len() == 0
+	metricFamily := &dto.MetricFamily{Metric: series}
+	if first || e.format.FormatType() != expfmt.TypeProtoDelim {
+		metricFamily.Name = family.base.Name
+		metricFamily.Help = family.base.Help
+		metricFamily.Type = family.base.Type
+		metricFamily.Unit = family.base.Unit
+	}
+	if e.format.FormatType() == expfmt.TypeProtoDelim {
+		// concatenated protobuf messages are decoded as one message where repeated metrics are appended
+		b, err := proto.Marshal(metricFamily)
+		if err != nil {
+			return err
+		}
+		family.encoded.Write(b)
+		return nil
+	}
+	e.scratch.Reset()
+	if err := expfmt.NewEncoder(&e.scratch, e.format, expfmt.WithCreatedLines()).Encode(metricFamily); err != nil {
+		return err
+	}
+	if first {
+		family.encoded.Write(e.scratch.Bytes())
+		return nil
+	}
+	writeSamplesOnly(&family.encoded, e.scratch.Bytes())
+	return nil
+}
+
+// writeTo writes all families encoded sorted by name
+func (e *streamEncoder) writeTo(w io.Writer) error {
+	if len(e.warnings) > 0 {
+		e.add(familyConflictName, familyConflictFamily(e.warnings))
+	}
+	if e.err != nil {
+		return e.err
+	}
+	names := make([]string, 0, len(e.families))
+	for name, family := range e.families {
+		if family.encoded.Len() > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		encoded := e.families[name].encoded
+		if e.format.FormatType() == expfmt.TypeProtoDelim {
+			if _, err := w.Write(binary.AppendUvarint(nil, uint64(encoded.Len()))); err != nil {
+				return err
+			}
+		}
+		if _, err := encoded.WriteTo(w); err != nil {
+			return err
+		}
+	}
+	if e.format.FormatType() == expfmt.TypeOpenMetrics {
+		if _, err := expfmt.FinalizeOpenMetrics(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// seriesHash gives a hash identifying series from its sorted labels
+func seriesHash(labels []*dto.LabelPair) uint64 {
+	hash := fnv.New64a()
+	for _, label := range labels {
+		io.WriteString(hash, label.GetName())
+		hash.Write([]byte{0xff})
+		io.WriteString(hash, label.GetValue())
+		hash.Write([]byte{0xff})
+	}
+	return hash.Sum64()
+}
+
+// writeSamplesOnly writes encoded family without its metadata lines (help, type and unit)
+func writeSamplesOnly(w *bytes.Buffer, encoded []byte) {
+	for len(encoded) > 0 {
+		end := bytes.IndexByte(encoded, '\n') + 1
+		if end == 0 {
+			end = len(encoded)
+		}
+		line := encoded[:end]
+		encoded = encoded[end:]
+		if bytes.HasPrefix(line, []byte("# ")) {
+			continue
+		}
+		w.Write(line)
+	}
+}
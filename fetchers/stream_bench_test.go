@@ -0,0 +1,165 @@
+package fetchers
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+
+	"github.com/orange-cloudfoundry/promconsulfetcher/models"
+)
+
+const (
+	benchInstances = 500
+	benchFamilies  = 50
+	benchSeries    = 10
+	// benchHeapSampleEvery is the number of instances between two measures of live heap
+	benchHeapSampleEvery = 50
+)
+
+// benchInstance gives synthetic metrics of an instance with benchFamilies families of benchSeries series,
+// metrics are built at each call as a scrape decodes them.
+func benchInstance(i int) scrapedMetrics {
+	metricType := dto.MetricType_GAUGE
+	route := &models.Route{
+		ServiceName:    "app",
+		ServiceID:      fmt.Sprintf("app-%d", i),
+		ServiceAddress: fmt.Sprintf("10.0.%d.%d", i/256, i%256),
+		ServicePort:    8080,
+	}
+	metricsGroup := make(map[string]*dto.MetricFamily, benchFamilies)
+	for fam := 0; fam < benchFamilies; fam++ {
+		name := fmt.Sprintf("family_%d", fam)
+		metricsList := make([]*dto.Metric, 0, benchSeries)
+		for s := 0; s < benchSeries; s++ {
+			metricsList = append(metricsList, &dto.Metric{
+				Label: []*dto.LabelPair{
+					{Name: ptrString("instance_id"), Value: ptrString(route.ServiceID)},
+					{Name: ptrString("series"), Value: ptrString(fmt.Sprintf("%d", s))},
+				},
+				Gauge: &dto.Gauge{Value: ptrFloat64(float64(s))},
+			})
+		}
+		metricsGroup[name] = &dto.MetricFamily{
+			Name:   ptrString(name),
+			Help:   ptrString("synthetic family"),
+			Type:   &metricType,
+			Metric: metricsList,
+		}
+	}
+	return scrapedMetrics{route: route, metrics: metricsGroup, samples: benchFamilies * benchSeries}
+}
+
+// firstByteWriter discards bytes written and records when first byte has been written
+type firstByteWriter struct {
+	first time.Time
+}
+
+func (w *firstByteWriter) Write(p []byte) (int, error) {
+	if w.first.IsZero() {
+		w.first = time.Now()
+	}
+	return len(p), nil
+}
+
+// heapSampler measures peak of live heap, timer is stopped while measuring
+type heapSampler struct {
+	b    *testing.B
+	base uint64
+	peak uint64
+}
+
+func newHeapSampler(b *testing.B) *heapSampler {
+	h := &heapSampler{b: b}
+	h.base = h.live()
+	return h
+}
+
+func (h *heapSampler) live() uint64 {
+	h.b.StopTimer()
+	defer h.b.StartTimer()
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.HeapAlloc
+}
+
+func (h *heapSampler) sample() {
+	if live := h.live(); live > h.base && live-h.base > h.peak {
+		h.peak = live - h.base
+	}
+}
+
+// benchmarkOutput gives instances one by one to deliver, as scrapes do, and then calls write.
+// It reports peak of live heap and time to first byte, which is time between last instance delivered
+// and first byte written.
+func benchmarkOutput(b *testing.B, deliver func(scraped scrapedMetrics), write func(w io.Writer)) {
+	var peak uint64
+	var ttfb time.Duration
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		heap := newHeapSampler(b)
+		for instance := 0; instance < benchInstances; instance++ {
+			deliver(benchInstance(instance))
+			if (instance+1)%benchHeapSampleEvery == 0 {
+				heap.sample()
+			}
+		}
+		w := &firstByteWriter{}
+		lastDelivered := time.Now()
+		write(w)
+		ttfb += w.first.Sub(lastDelivered)
+		heap.sample()
+		if heap.peak > peak {
+			peak = heap.peak
+		}
+	}
+	b.ReportMetric(float64(peak), "peak-heap-B")
+	b.ReportMetric(float64(ttfb.Nanoseconds())/float64(b.N), "ttfb-ns")
+}
+
+func BenchmarkOutputMerged(b *testing.B) {
+	f := MetricsFetcher{}
+	var scraped []scrapedMetrics
+	benchmarkOutput(b, func(s scrapedMetrics) {
+		scraped = append(scraped, s)
+	}, func(w io.Writer) {
+		f.applyResponseSampleLimit(scraped)
+		merged := f.mergeMetrics(scraped)
+		f.sortSeries(merged)
+		scraped = nil
+		names := make([]string, 0, len(merged))
+		for name := range merged {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		encoder := expfmt.NewEncoder(w, expfmt.NewFormat(expfmt.TypeTextPlain))
+		for _, name := range names {
+			if err := encoder.Encode(merged[name]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkOutputStream(b *testing.B) {
+	f := MetricsFetcher{}
+	var encoder *streamEncoder
+	benchmarkOutput(b, func(s scrapedMetrics) {
+		if encoder == nil {
+			encoder = f.newStreamEncoder(expfmt.NewFormat(expfmt.TypeTextPlain))
+		}
+		encoder.addScraped(s)
+	}, func(w io.Writer) {
+		if err := encoder.writeTo(w); err != nil {
+			b.Fatal(err)
+		}
+		encoder = nil
+	})
+}
@@ -0,0 +1,147 @@
+package fetchers_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/onsi/gomega/ghttp"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/orange-cloudfoundry/promconsulfetcher/config"
+	"github.com/orange-cloudfoundry/promconsulfetcher/models"
+)
+
+var _ = Describe("Stream", func() {
+	const content = "# HELP foo foo help\n# TYPE foo counter\nfoo{a=\"1\"} 1\nfoo{a=\"2\"} 2\n# HELP bar bar help\n# TYPE bar gauge\nbar 3\n"
+	var servers []*ghttp.Server
+	var routes []*models.Route
+
+	startInstances := func(contents ...string) {
+		for i, content := range contents {
+			server, route := newInstance([]string{"app-1", "app-2", "app-3"}[i], content)
+			servers = append(servers, server)
+			routes = append(routes, route)
+		}
+	}
+	// delayFirstInstance makes first instance respond last to check that results don't depend on scrape order
+	delayFirstInstance := func(content string) {
+		servers[0].RouteToHandler(http.MethodGet, "/metrics", ghttp.CombineHandlers(
+			func(w http.ResponseWriter, req *http.Request) {
+				time.Sleep(100 * time.Millisecond)
+			},
+			ghttp.RespondWith(http.StatusOK, content),
+		))
+	}
+	stream := func(c config.Config, format expfmt.Format) []byte {
+		buf := &bytes.Buffer{}
+		err := newMetricsFetcher(c, routes...).StreamMetrics(
+			context.Background(), models.ServiceSearch{Name: "app"}, scrapeDefaults, false, http.Header{}, buf, format,
+		)
+		Expect(err).ToNot(HaveOccurred())
+		return buf.Bytes()
+	}
+	decode := func(content []byte, format expfmt.Format) map[string]*dto.MetricFamily {
+		metricsGroup := make(map[string]*dto.MetricFamily)
+		// decoder reads through a new bufio.Reader at each call which must not buffer past the message
+		decoder := expfmt.NewDecoder(bufio.NewReader(bytes.NewReader(content)), format)
+		for {
+			mf := &dto.MetricFamily{}
+			err := decoder.Decode(mf)
+			if errors.Is(err, io.EOF) {
+				return metricsGroup
+			}
+			Expect(err).ToNot(HaveOccurred())
+			Expect(metricsGroup).ToNot(HaveKey(mf.GetName()))
+			metricsGroup[mf.GetName()] = mf
+		}
+	}
+
+	AfterEach(func() {
+		for _, server := range servers {
+			server.Close()
+		}
+		servers = nil
+		routes = nil
+	})
+
+	It("writes each family once with series of all instances in text format", func() {
+		startInstances(content, content, content)
+		format := expfmt.NewFormat(expfmt.TypeTextPlain)
+		output := stream(defaultConfig(), format)
+		Expect(strings.Count(string(output), "# HELP foo ")).To(Equal(1))
+		Expect(strings.Count(string(output), "# TYPE foo ")).To(Equal(1))
+		Expect(strings.Index(string(output), "# HELP bar ")).To(BeNumerically("<", strings.Index(string(output), "# HELP foo ")))
+
+		var parser expfmt.TextParser
+		metricsGroup, err := parser.TextToMetricFamilies(bytes.NewReader(output))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(metricsGroup["foo"].Metric).To(HaveLen(6))
+		Expect(metricsGroup["bar"].Metric).To(HaveLen(3))
+		Expect(metricsGroup["up"].Metric).To(HaveLen(3))
+	})
+
+	It("writes families in delimited protobuf format", func() {
+		startInstances(content, content)
+		format := expfmt.NewFormat(expfmt.TypeProtoDelim)
+		metricsGroup := decode(stream(defaultConfig(), format), format)
+		Expect(metricsGroup["foo"].GetType()).To(Equal(dto.MetricType_COUNTER))
+		Expect(metricsGroup["foo"].GetHelp()).To(Equal("foo help"))
+		Expect(metricsGroup["foo"].Metric).To(HaveLen(4))
+		Expect(metricsGroup["bar"].Metric).To(HaveLen(2))
+	})
+
+	It("ends openmetrics format with EOF", func() {
+		startInstances(content, content)
+		output := stream(defaultConfig(), expfmt.NewFormat(expfmt.TypeOpenMetrics))
+		Expect(string(output)).To(HaveSuffix("# EOF\n"))
+		Expect(strings.Count(string(output), "# TYPE foo ")).To(Equal(1))
+	})
+
+	It("resolves family conflicts in order of instances", func() {
+		startInstances(content, "# TYPE foo gauge\nfoo{a=\"3\"} 3\n")
+		delayFirstInstance(content)
+		c := defaultConfig()
+		c.MetricFamilyConflict = models.FamilyConflictDrop
+		format := expfmt.NewFormat(expfmt.TypeProtoDelim)
+		metricsGroup := decode(stream(c, format), format)
+		Expect(metricsGroup["foo"].GetType()).To(Equal(dto.MetricType_COUNTER))
+		Expect(metricsGroup["foo"].Metric).To(HaveLen(2))
+		Expect(metricsGroup).To(HaveKey("promconsulfetcher_metric_family_conflict"))
+	})
+
+	It("resolves duplicate series in order of instances", func() {
+		startInstances("foo 1\n", "foo 2\n")
+		delayFirstInstance("foo 1\n")
+		c := defaultConfig()
+		c.RouteLabels.Exclude = models.RouteLabelNames
+		format := expfmt.NewFormat(expfmt.TypeProtoDelim)
+		metricsGroup := decode(stream(c, format), format)
+		Expect(metricsGroup["foo"].Metric).To(HaveLen(2))
+		Expect(hasLabel(metricsGroup["foo"].Metric[0], "duplicate")).To(BeFalse())
+		Expect(metricsGroup["foo"].Metric[0].GetUntyped().GetValue()).To(Equal(1.0))
+		Expect(labelValue(metricsGroup["foo"].Metric[1], "duplicate")).To(Equal("1"))
+		Expect(metricsGroup["foo"].Metric[1].GetUntyped().GetValue()).To(Equal(2.0))
+	})
+
+	It("replaces metrics of last instances over response sample limit", func() {
+		startInstances(content, content, content)
+		delayFirstInstance(content)
+		c := defaultConfig()
+		c.Limits.ResponseSampleLimit = 7
+		format := expfmt.NewFormat(expfmt.TypeProtoDelim)
+		metricsGroup := decode(stream(c, format), format)
+		Expect(metricsGroup["foo"].Metric).To(HaveLen(4))
+		Expect(metricsGroup["promconsulfetcher_scrape_error"].Metric).To(HaveLen(1))
+		Expect(labelValue(metricsGroup["promconsulfetcher_scrape_error"].Metric[0], "service_id")).To(Equal("app-3"))
+	})
+})
@@ -48,18 +48,22 @@ func main() {
 	}
 
 	backendFactory := clients.NewBackendFactory(*c)
-	scraper := scrapers.NewScraper(backendFactory)
+	scraper := scrapers.NewScraper(backendFactory, *c)
 
 	healthCheck := healthchecks.NewHealthCheck()
 	routeFetcher, err := fetchers.NewRoutesFetcher(c.ConsulConfig)
 	if err != nil {
 		log.Fatal("Error loading route fetcher: ", err.Error())
 	}
-	metricsFetcher := fetchers.NewMetricsFetcher(scraper, routeFetcher, c.ExternalExporters)
+	var routesFetch fetchers.RoutesFetch = routeFetcher
+	if c.ConsulConfig.Cache.Enabled {
+		routesFetch = fetchers.NewRoutesCache(routeFetcher, c.ConsulConfig.Cache.IdleTimeout.Duration())
+	}
+	metricsFetcher := fetchers.NewMetricsFetcher(scraper, routesFetch, *c)
 
 	rtr := mux.NewRouter()
 	api.Register(
-		rtr, metricsFetcher,
+		rtr, metricsFetcher, c.OutputMode,
 		userdocs.NewUserDoc(c.BaseURL),
 	)
 
@@ -37,6 +37,97 @@ var (
 		},
 		[]string{},
 	)
+	RoutesCacheHitsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "promconsulfetcher_routes_cache_hits_total",
+			Help: "Number of routes served from routes cache.",
+		},
+	)
+	RoutesCacheMissesTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "promconsulfetcher_routes_cache_misses_total",
+			Help: "Number of routes not found in routes cache and retrieved from consul.",
+		},
+	)
+	RoutesCacheStaleness = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "promconsulfetcher_routes_cache_staleness_seconds",
+			Help: "Time in seconds since routes in cache of service lost contact with consul, 0 when in contact.",
+		},
+		[]string{"service_name"},
+	)
+	ScrapesInFlight = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "promconsulfetcher_scrapes_in_flight",
+			Help: "Number of instances scrapes currently in flight.",
+		},
+	)
+	ScrapesQueued = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "promconsulfetcher_scrapes_queued",
+			Help: "Number of instances scrapes waiting for a free slot.",
+		},
+	)
+	DeduplicatedRequestsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "promconsulfetcher_deduplicated_requests_total",
+			Help: "Number of requests which shared result of an identical request in flight or cached.",
+		},
+	)
+	MetricFamilyConflictsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "promconsulfetcher_metric_family_conflicts_total",
+			Help: "Number of metric families from an instance in conflict with same family from another instance.",
+		},
+		[]string{"service_name", "family", "conflict", "resolution"},
+	)
+	DuplicateSeriesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "promconsulfetcher_duplicate_series_total",
+			Help: "Number of series with the same labels than another series of the same family after merge.",
+		},
+		[]string{"family", "resolution"},
+	)
+	LimitHitsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "promconsulfetcher_limit_hits_total",
+			Help: "Number of instances scrapes rejected because they exceeded a limit.",
+		},
+		[]string{"service_name", "limit"},
+	)
+	BackendTransports = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "promconsulfetcher_backend_transports",
+			Help: "Number of transports to instances kept in pool.",
+		},
+	)
+	BackendTransportsCreatedTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "promconsulfetcher_backend_transports_created_total",
+			Help: "Number of transports to instances created because none was found in pool.",
+		},
+	)
+	BackendTransportsEvictedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "promconsulfetcher_backend_transports_evicted_total",
+			Help: "Number of transports to instances evicted from pool.",
+		},
+		[]string{"reason"},
+	)
+	BackendConnectionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "promconsulfetcher_backend_connections_total",
+			Help: "Number of connections to instances used by scrapes, reused from a previous scrape or not.",
+		},
+		[]string{"reused"},
+	)
+	ScrapeQueueWaitSeconds = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "promconsulfetcher_scrape_queue_wait_seconds",
+			Help:    "Time waited by instances scrapes for a free slot.",
+			Buckets: []float64{0.001, 0.01, 0.05, 0.1, 0.5, 1, 2.5, 5, 10},
+		},
+	)
 )
 
 func RouteToLabel(route *models.Route) prometheus.Labels {
@@ -70,4 +161,18 @@ func init() {
 	prometheus.MustRegister(LatestScrapeRoute)
 	prometheus.MustRegister(ScrapeRouteFailedTotal)
 	prometheus.MustRegister(MetricFetchSuccessTotal)
+	prometheus.MustRegister(RoutesCacheHitsTotal)
+	prometheus.MustRegister(RoutesCacheMissesTotal)
+	prometheus.MustRegister(RoutesCacheStaleness)
+	prometheus.MustRegister(ScrapesInFlight)
+	prometheus.MustRegister(ScrapesQueued)
+	prometheus.MustRegister(ScrapeQueueWaitSeconds)
+	prometheus.MustRegister(DeduplicatedRequestsTotal)
+	prometheus.MustRegister(MetricFamilyConflictsTotal)
+	prometheus.MustRegister(DuplicateSeriesTotal)
+	prometheus.MustRegister(LimitHitsTotal)
+	prometheus.MustRegister(BackendTransports)
+	prometheus.MustRegister(BackendTransportsCreatedTotal)
+	prometheus.MustRegister(BackendTransportsEvictedTotal)
+	prometheus.MustRegister(BackendConnectionsTotal)
 }
@@ -0,0 +1,81 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// AddressService is the address of the service instance
+	AddressService = "service"
+	// AddressNode is the address of the node where service instance is
+	AddressNode = "node"
+	// AddressTaggedPrefix is the prefix to use to select a node tagged address (e.g. `tagged:wan`)
+	AddressTaggedPrefix = "tagged:"
+)
+
+// DefaultAddressPolicy uses service address and falls back to node address
+var DefaultAddressPolicy = AddressPolicy{AddressService, AddressNode}
+
+// AddressPolicy is an ordered list of addresses to choose for scraping,
+// first address found on route is used.
+type AddressPolicy []string
+
+// ParseAddressPolicy parses a comma separated list of addresses,
+// each address can be `service`, `node` or `tagged:<tagged address name>` (e.g. `tagged:wan,service`).
+func ParseAddressPolicy(policy string) (AddressPolicy, error) {
+	if strings.TrimSpace(policy) == "" {
+		return AddressPolicy{}, nil
+	}
+	addrPolicy := make(AddressPolicy, 0)
+	for _, addr := range strings.Split(policy, ",") {
+		addr = strings.TrimSpace(addr)
+		switch {
+		case addr == AddressService, addr == AddressNode:
+		case strings.HasPrefix(addr, AddressTaggedPrefix) && len(addr) > len(AddressTaggedPrefix):
+		default:
+			return nil, fmt.Errorf(
+				"invalid address %q in address policy, must be one of %s, %s or %s<name>",
+				addr, AddressService, AddressNode, AddressTaggedPrefix,
+			)
+		}
+		addrPolicy = append(addrPolicy, addr)
+	}
+	return addrPolicy, nil
+}
+
+func (p *AddressPolicy) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var policy string
+	if err := unmarshal(&policy); err != nil {
+		return err
+	}
+	addrPolicy, err := ParseAddressPolicy(policy)
+	if err != nil {
+		return err
+	}
+	*p = addrPolicy
+	return nil
+}
+
+// Address gives the first address found for route following policy order, it returns empty string if none found.
+func (p AddressPolicy) Address(route *Route) string {
+	for _, addr := range p {
+		value := ""
+		switch {
+		case addr == AddressService:
+			value = route.ServiceAddress
+		case addr == AddressNode:
+			value = route.Address
+		case strings.HasPrefix(addr, AddressTaggedPrefix):
+			value = route.TaggedAddresses[strings.TrimPrefix(addr, AddressTaggedPrefix)]
+		}
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func (p AddressPolicy) String() string {
+	return strings.Join(p, ",")
+}
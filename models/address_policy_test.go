@@ -0,0 +1,47 @@
+package models_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/orange-cloudfoundry/promconsulfetcher/models"
+)
+
+var _ = Describe("AddressPolicy", func() {
+	route := &models.Route{
+		Address:        "10.0.0.1",
+		ServiceAddress: "",
+		TaggedAddresses: map[string]string{
+			"lan_ipv4": "10.0.0.1",
+			"wan":      "2001:db8::1",
+		},
+	}
+
+	Context("ParseAddressPolicy", func() {
+		It("parses a list of addresses", func() {
+			policy, err := models.ParseAddressPolicy("tagged:wan, service,node")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(policy).To(Equal(models.AddressPolicy{"tagged:wan", "service", "node"}))
+		})
+
+		It("rejects unknown address", func() {
+			_, err := models.ParseAddressPolicy("service,wan")
+			Expect(err).Should(HaveOccurred())
+
+			_, err = models.ParseAddressPolicy("tagged:")
+			Expect(err).Should(HaveOccurred())
+		})
+	})
+
+	Context("Address", func() {
+		It("gives first address found following policy order", func() {
+			Expect(models.AddressPolicy{"tagged:wan", "node"}.Address(route)).To(Equal("2001:db8::1"))
+			Expect(models.AddressPolicy{"tagged:wan_ipv4", "service", "node"}.Address(route)).To(Equal("10.0.0.1"))
+			Expect(models.DefaultAddressPolicy.Address(route)).To(Equal("10.0.0.1"))
+		})
+
+		It("gives empty address when none found", func() {
+			Expect(models.AddressPolicy{"tagged:wan_ipv4", "service"}.Address(route)).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,129 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/common/model"
+)
+
+// Label is a label name with its value
+type Label struct {
+	Name  string
+	Value string
+}
+
+// LabelMapping maps a consul key (meta key, tagged address name or tag key) to a label,
+// when label is empty the key sanitized and prefixed is used as label name.
+type LabelMapping struct {
+	Key   string `yaml:"key"`
+	Label string `yaml:"label"`
+}
+
+// UnmarshalYAML accepts a key only (e.g. `version`) or a key with its label (e.g. `{key: version, label: app_version}`)
+func (m *LabelMapping) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var key string
+	if err := unmarshal(&key); err == nil {
+		*m = LabelMapping{Key: key}
+		return m.validate()
+	}
+	type plain LabelMapping
+	if err := unmarshal((*plain)(m)); err != nil {
+		return err
+	}
+	return m.validate()
+}
+
+func (m LabelMapping) validate() error {
+	if m.Key == "" {
+		return fmt.Errorf("key must be set in label mapping")
+	}
+	if m.Label != "" && !model.LabelName(m.Label).IsValid() {
+		return fmt.Errorf("invalid label name %q for key %s", m.Label, m.Key)
+	}
+	return nil
+}
+
+// LabelMappings is an allowlist of consul keys to expose as labels
+type LabelMappings []LabelMapping
+
+// ConsulLabels selects service meta, node meta, tagged addresses and tags from consul to inject as labels on metrics.
+// Only listed keys are injected to guard against labels cardinality.
+type ConsulLabels struct {
+	// Prefix is added to label names which are not explicitly set in mapping,
+	// labels with a prefix starting with `__` (e.g. `__meta_consul_`) are only available during relabeling.
+	Prefix          string        `yaml:"prefix"`
+	ServiceMeta     LabelMappings `yaml:"service_meta"`
+	NodeMeta        LabelMappings `yaml:"node_meta"`
+	TaggedAddresses LabelMappings `yaml:"tagged_addresses"`
+	// Tags are keys of tags in form `key=value`
+	Tags LabelMappings `yaml:"tags"`
+}
+
+func (c *ConsulLabels) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain ConsulLabels
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	if c.Prefix != "" && !model.LabelName(c.Prefix).IsValid() {
+		return fmt.Errorf("invalid labels prefix %q", c.Prefix)
+	}
+	names := make(map[string]bool)
+	for _, name := range c.Names() {
+		if names[name] {
+			return fmt.Errorf("label %s is given by more than one consul key", name)
+		}
+		names[name] = true
+	}
+	return nil
+}
+
+// Labels gives labels from route for keys listed, keys without value on route are not given
+func (c ConsulLabels) Labels(route *Route) []Label {
+	tags := make(map[string]string)
+	if len(c.Tags) > 0 {
+		for _, t := range route.ServiceTags {
+			kv := strings.SplitN(t, "=", 2)
+			if len(kv) == 2 {
+				tags[kv[0]] = kv[1]
+			}
+		}
+	}
+	labels := make([]Label, 0)
+	for _, source := range []struct {
+		mappings LabelMappings
+		values   map[string]string
+	}{
+		{c.ServiceMeta, route.ServiceMeta},
+		{c.NodeMeta, route.NodeMeta},
+		{c.TaggedAddresses, route.TaggedAddresses},
+		{c.Tags, tags},
+	} {
+		for _, mapping := range source.mappings {
+			value := source.values[mapping.Key]
+			if value == "" {
+				continue
+			}
+			labels = append(labels, Label{Name: c.labelName(mapping), Value: value})
+		}
+	}
+	return labels
+}
+
+// Names gives names of all labels which can be given from consul
+func (c ConsulLabels) Names() []string {
+	names := make([]string, 0)
+	for _, mappings := range []LabelMappings{c.ServiceMeta, c.NodeMeta, c.TaggedAddresses, c.Tags} {
+		for _, mapping := range mappings {
+			names = append(names, c.labelName(mapping))
+		}
+	}
+	return names
+}
+
+func (c ConsulLabels) labelName(mapping LabelMapping) string {
+	if mapping.Label != "" {
+		return mapping.Label
+	}
+	return SanitizeLabelName(c.Prefix + mapping.Key)
+}
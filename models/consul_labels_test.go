@@ -0,0 +1,68 @@
+package models_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/yaml.v2"
+
+	"github.com/orange-cloudfoundry/promconsulfetcher/models"
+)
+
+var _ = Describe("ConsulLabels", func() {
+	route := &models.Route{
+		ServiceMeta:     map[string]string{"version": "1.2.0", "team-name": "ops", "secret": "s"},
+		NodeMeta:        map[string]string{"az": "z1"},
+		TaggedAddresses: map[string]string{"wan": "1.1.1.1"},
+		ServiceTags:     models.ServiceTags{"env=prod", "other"},
+	}
+
+	It("gives only listed keys with prefix and sanitized names", func() {
+		var consulLabels models.ConsulLabels
+		err := yaml.Unmarshal([]byte(`
+prefix: consul_
+service_meta: [version, team-name, missing]
+node_meta: [{key: az, label: zone}]
+tagged_addresses: [wan]
+tags: [env]
+`), &consulLabels)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(consulLabels.Labels(route)).To(Equal([]models.Label{
+			{Name: "consul_version", Value: "1.2.0"},
+			{Name: "consul_team_name", Value: "ops"},
+			{Name: "zone", Value: "z1"},
+			{Name: "consul_wan", Value: "1.1.1.1"},
+			{Name: "consul_env", Value: "prod"},
+		}))
+	})
+
+	It("gives names of all labels", func() {
+		var consulLabels models.ConsulLabels
+		err := yaml.Unmarshal([]byte(`
+prefix: consul_
+service_meta: [team-name]
+node_meta: [{key: az, label: zone}]
+tags: [env]
+`), &consulLabels)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(consulLabels.Names()).To(Equal([]string{"consul_team_name", "zone", "consul_env"}))
+	})
+
+	It("rejects invalid label names and prefix", func() {
+		var consulLabels models.ConsulLabels
+		Expect(yaml.Unmarshal([]byte(`{service_meta: [{key: version, label: "app-version"}]}`), &consulLabels)).
+			Should(HaveOccurred())
+		Expect(yaml.Unmarshal([]byte(`{prefix: "consul-"}`), &consulLabels)).Should(HaveOccurred())
+	})
+
+	It("rejects keys giving the same label name", func() {
+		var consulLabels models.ConsulLabels
+		Expect(yaml.Unmarshal([]byte(`{service_meta: [team-name], tags: [team_name]}`), &consulLabels)).
+			Should(HaveOccurred())
+		Expect(yaml.Unmarshal([]byte(`{service_meta: [version], node_meta: [{key: az, label: version}]}`), &consulLabels)).
+			Should(HaveOccurred())
+		Expect(yaml.Unmarshal([]byte(`{service_meta: [version], node_meta: [version]}`), &consulLabels)).
+			Should(HaveOccurred())
+		Expect(yaml.Unmarshal([]byte(`{service_meta: [version], node_meta: [{key: version, label: node_version}]}`), &consulLabels)).
+			ShouldNot(HaveOccurred())
+	})
+})
@@ -0,0 +1,32 @@
+package models
+
+import "fmt"
+
+// DuplicateSeries is the resolution of series of a family with the same labels after merge, empty means disambiguate.
+type DuplicateSeries string
+
+const (
+	// DuplicateSeriesDisambiguate adds label `duplicate` with index of duplicate on series with the same labels
+	DuplicateSeriesDisambiguate DuplicateSeries = "disambiguate"
+	// DuplicateSeriesDrop only keeps first series with the same labels
+	DuplicateSeriesDrop DuplicateSeries = "drop"
+
+	DuplicateLabel = "duplicate"
+)
+
+func (d *DuplicateSeries) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var resolution string
+	if err := unmarshal(&resolution); err != nil {
+		return err
+	}
+	switch DuplicateSeries(resolution) {
+	case "", DuplicateSeriesDisambiguate, DuplicateSeriesDrop:
+	default:
+		return fmt.Errorf(
+			"invalid duplicate series resolution %q, must be one of %s or %s",
+			resolution, DuplicateSeriesDisambiguate, DuplicateSeriesDrop,
+		)
+	}
+	*d = DuplicateSeries(resolution)
+	return nil
+}
@@ -0,0 +1,36 @@
+package models
+
+import "fmt"
+
+// FamilyConflict is the resolution of conflicts between metric families with same name
+// but a different type or help, empty means keep_first.
+type FamilyConflict string
+
+const (
+	// FamilyConflictKeepFirst keeps type and help of first family seen, samples of other types are converted when possible
+	FamilyConflictKeepFirst FamilyConflict = "keep_first"
+	// FamilyConflictDrop drops family of instance in conflict
+	FamilyConflictDrop FamilyConflict = "drop"
+	// FamilyConflictRename renames family of instance with a different type to `<name>_<type>`
+	FamilyConflictRename FamilyConflict = "rename"
+
+	ConflictType = "type"
+	ConflictHelp = "help"
+)
+
+func (c *FamilyConflict) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var resolution string
+	if err := unmarshal(&resolution); err != nil {
+		return err
+	}
+	switch FamilyConflict(resolution) {
+	case "", FamilyConflictKeepFirst, FamilyConflictDrop, FamilyConflictRename:
+	default:
+		return fmt.Errorf(
+			"invalid metric family conflict resolution %q, must be one of %s, %s or %s",
+			resolution, FamilyConflictKeepFirst, FamilyConflictDrop, FamilyConflictRename,
+		)
+	}
+	*c = FamilyConflict(resolution)
+	return nil
+}
@@ -0,0 +1,248 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var filterSelectorRe = regexp.MustCompile(`\A[[:alpha:]_][[:word:]\-]*(\.[[:word:]\-]+|\["[^"]*"\]|\[` + "`[^`]*`" + `\])*\z`)
+
+// ValidFilter checks that filter is a syntactically valid consul filter expression
+// as described in https://developer.hashicorp.com/consul/api-docs/features/filtering
+// Selectors are only checked syntactically as available ones depend on consul endpoint.
+// Empty filter is valid and means no filtering.
+func ValidFilter(filter string) error {
+	if strings.TrimSpace(filter) == "" {
+		return nil
+	}
+	tokens, err := tokenizeFilter(filter)
+	if err != nil {
+		return fmt.Errorf("invalid filter %q: %s", filter, err.Error())
+	}
+	p := &filterParser{tokens: tokens}
+	err = p.parseOr()
+	if err == nil && !p.end() {
+		err = fmt.Errorf("unexpected %q", p.peek().value)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid filter %q: %s", filter, err.Error())
+	}
+	return nil
+}
+
+type filterTokenKind int
+
+const (
+	filterTokenWord filterTokenKind = iota
+	filterTokenString
+	filterTokenOperator
+	filterTokenOpenParen
+	filterTokenCloseParen
+)
+
+type filterToken struct {
+	kind  filterTokenKind
+	value string
+}
+
+func tokenizeFilter(filter string) ([]filterToken, error) {
+	tokens := make([]filterToken, 0)
+	runes := []rune(filter)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, filterToken{kind: filterTokenOpenParen, value: "("})
+			i++
+		case r == ')':
+			tokens = append(tokens, filterToken{kind: filterTokenCloseParen, value: ")"})
+			i++
+		case r == '=' || r == '!':
+			if i+1 >= len(runes) || runes[i+1] != '=' {
+				return nil, fmt.Errorf("unknown operator %q at position %d", string(r), i)
+			}
+			tokens = append(tokens, filterToken{kind: filterTokenOperator, value: string(runes[i : i+2])})
+			i += 2
+		case r == '"' || r == '`':
+			end := i + 1
+			for end < len(runes) && runes[end] != r {
+				if r == '"' && runes[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(runes) {
+				return nil, fmt.Errorf("unterminated string at position %d", i)
+			}
+			tokens = append(tokens, filterToken{kind: filterTokenString, value: string(runes[i+1 : end])})
+			i = end + 1
+		default:
+			end := i
+			for end < len(runes) && !unicode.IsSpace(runes[end]) && !strings.ContainsRune(`()"=!`+"`", runes[end]) {
+				// index on selector (e.g. ServiceMeta["key"]) can contain any char
+				if runes[end] == '[' {
+					start := end
+					for end < len(runes) && runes[end] != ']' {
+						end++
+					}
+					if end >= len(runes) {
+						return nil, fmt.Errorf("unterminated index at position %d", start)
+					}
+				}
+				end++
+			}
+			tokens = append(tokens, filterToken{kind: filterTokenWord, value: string(runes[i:end])})
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+}
+
+func (p *filterParser) end() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *filterParser) peek() filterToken {
+	if p.end() {
+		return filterToken{}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() (filterToken, error) {
+	if p.end() {
+		return filterToken{}, fmt.Errorf("unexpected end of expression")
+	}
+	p.pos++
+	return p.tokens[p.pos-1], nil
+}
+
+// acceptKeyword consumes next token if it is the given keyword
+func (p *filterParser) acceptKeyword(keyword string) bool {
+	t := p.peek()
+	if !p.end() && t.kind == filterTokenWord && strings.EqualFold(t.value, keyword) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) parseOr() error {
+	if err := p.parseAnd(); err != nil {
+		return err
+	}
+	for p.acceptKeyword("or") {
+		if err := p.parseAnd(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *filterParser) parseAnd() error {
+	if err := p.parseNot(); err != nil {
+		return err
+	}
+	for p.acceptKeyword("and") {
+		if err := p.parseNot(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *filterParser) parseNot() error {
+	if p.acceptKeyword("not") {
+		return p.parseNot()
+	}
+	if p.peek().kind == filterTokenOpenParen && !p.end() {
+		p.pos++
+		if err := p.parseOr(); err != nil {
+			return err
+		}
+		t, err := p.next()
+		if err != nil {
+			return err
+		}
+		if t.kind != filterTokenCloseParen {
+			return fmt.Errorf("expected ')' but got %q", t.value)
+		}
+		return nil
+	}
+	return p.parseMatch()
+}
+
+// parseMatch parses one of:
+// <Selector> (==|!=|contains|not contains|matches|not matches) <Value>
+// <Selector> is (not) empty
+// <Value> (not) in <Selector>
+func (p *filterParser) parseMatch() error {
+	first, err := p.next()
+	if err != nil {
+		return err
+	}
+	if first.kind != filterTokenWord && first.kind != filterTokenString {
+		return fmt.Errorf("expected selector or value but got %q", first.value)
+	}
+
+	if p.acceptKeyword("is") {
+		p.acceptKeyword("not")
+		if !p.acceptKeyword("empty") {
+			return fmt.Errorf("expected 'empty' after 'is'")
+		}
+		return p.checkSelector(first)
+	}
+
+	negate := p.acceptKeyword("not")
+	if p.acceptKeyword("in") {
+		selector, err := p.next()
+		if err != nil {
+			return err
+		}
+		return p.checkSelector(selector)
+	}
+	if p.acceptKeyword("contains") || p.acceptKeyword("matches") {
+		return p.parseValueFor(first)
+	}
+	if negate {
+		return fmt.Errorf("expected 'in', 'contains' or 'matches' after 'not'")
+	}
+	op, err := p.next()
+	if err != nil {
+		return err
+	}
+	if op.kind != filterTokenOperator {
+		return fmt.Errorf("expected operator but got %q", op.value)
+	}
+	return p.parseValueFor(first)
+}
+
+func (p *filterParser) parseValueFor(selector filterToken) error {
+	if err := p.checkSelector(selector); err != nil {
+		return err
+	}
+	value, err := p.next()
+	if err != nil {
+		return err
+	}
+	if value.kind != filterTokenWord && value.kind != filterTokenString {
+		return fmt.Errorf("expected value but got %q", value.value)
+	}
+	return nil
+}
+
+func (p *filterParser) checkSelector(selector filterToken) error {
+	if selector.kind != filterTokenWord || !filterSelectorRe.MatchString(selector.value) {
+		return fmt.Errorf("invalid selector %q", selector.value)
+	}
+	return nil
+}
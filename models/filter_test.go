@@ -0,0 +1,46 @@
+package models_test
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/orange-cloudfoundry/promconsulfetcher/models"
+)
+
+var _ = Describe("Filter", func() {
+	Context("ValidFilter", func() {
+		It("accepts valid filters", func() {
+			for _, filter := range []string{
+				"",
+				`ServiceMeta.version == "1.0"`,
+				`ServiceMeta["my-key"] != "foo" and NodeMeta.az == az1`,
+				`"primary" in ServiceTags and "canary" not in ServiceTags`,
+				`not (ServiceMeta.env == prod or ServiceMeta.env is empty)`,
+				`Service.Tags is not empty and Node.Node matches "^node-[0-9]+"`,
+				"ServiceAddress not contains `10.0.`",
+			} {
+				Expect(models.ValidFilter(filter)).ShouldNot(HaveOccurred(), filter)
+			}
+		})
+
+		It("rejects malformed filters", func() {
+			for _, filter := range []string{
+				`ServiceMeta.version = "1.0"`,
+				`ServiceMeta.version == "1.0`,
+				`ServiceMeta.version ==`,
+				`(ServiceMeta.version == "1.0"`,
+				`ServiceMeta.version == "1.0" and`,
+				`"primary" ServiceTags`,
+				`ServiceMeta..version == "1.0"`,
+				`ServiceTags is foo`,
+				`ServiceMeta[`,
+				"ServiceMeta[" + strings.Repeat("a", 20),
+				"ServiceMeta[" + strings.Repeat("a", 24) + ` == "1.0"`,
+			} {
+				Expect(models.ValidFilter(filter)).Should(HaveOccurred(), filter)
+			}
+		})
+	})
+})
@@ -0,0 +1,28 @@
+package models
+
+import "fmt"
+
+// OutputMode is the way metrics of instances are written, empty means merged.
+type OutputMode string
+
+const (
+	// OutputMerged merges metrics of all instances in memory before writing them
+	OutputMerged OutputMode = "merged"
+	// OutputStream encodes metrics of each instance in order of instances and writes them grouped by family
+	// once all instances have reported
+	OutputStream OutputMode = "stream"
+)
+
+func (m *OutputMode) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var mode string
+	if err := unmarshal(&mode); err != nil {
+		return err
+	}
+	switch OutputMode(mode) {
+	case "", OutputMerged, OutputStream:
+	default:
+		return fmt.Errorf("invalid output mode %q, must be one of %s or %s", mode, OutputMerged, OutputStream)
+	}
+	*m = OutputMode(mode)
+	return nil
+}
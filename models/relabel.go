@@ -0,0 +1,264 @@
+package models
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	RelabelReplace   = "replace"
+	RelabelKeep      = "keep"
+	RelabelDrop      = "drop"
+	RelabelLabelMap  = "labelmap"
+	RelabelLabelDrop = "labeldrop"
+	RelabelLabelKeep = "labelkeep"
+	RelabelHashMod   = "hashmod"
+)
+
+// RelabelRegexp is an anchored regular expression used in relabel configs
+type RelabelRegexp struct {
+	*regexp.Regexp
+	original string
+}
+
+// NewRelabelRegexp compiles regex anchored on both ends as prometheus does
+func NewRelabelRegexp(regex string) (RelabelRegexp, error) {
+	re, err := regexp.Compile("^(?:" + regex + ")$")
+	if err != nil {
+		return RelabelRegexp{}, err
+	}
+	return RelabelRegexp{Regexp: re, original: regex}, nil
+}
+
+func (r *RelabelRegexp) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var regex string
+	if err := unmarshal(&regex); err != nil {
+		return err
+	}
+	re, err := NewRelabelRegexp(regex)
+	if err != nil {
+		return err
+	}
+	*r = re
+	return nil
+}
+
+func (r RelabelRegexp) String() string {
+	return r.original
+}
+
+// RelabelConfig is a relabeling rule with the same semantics as prometheus `metric_relabel_configs`
+type RelabelConfig struct {
+	SourceLabels []string      `yaml:"source_labels,flow,omitempty"`
+	Separator    string        `yaml:"separator,omitempty"`
+	Regex        RelabelRegexp `yaml:"regex,omitempty"`
+	Modulus      uint64        `yaml:"modulus,omitempty"`
+	TargetLabel  string        `yaml:"target_label,omitempty"`
+	Replacement  string        `yaml:"replacement,omitempty"`
+	Action       string        `yaml:"action,omitempty"`
+}
+
+var defaultRelabelRegexp, _ = NewRelabelRegexp("(.*)")
+
+func (c *RelabelConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = RelabelConfig{
+		Separator:   ";",
+		Regex:       defaultRelabelRegexp,
+		Replacement: "$1",
+		Action:      RelabelReplace,
+	}
+	type plain RelabelConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	return c.Validate()
+}
+
+// Validate checks that relabel config is usable for its action
+func (c *RelabelConfig) Validate() error {
+	c.Action = strings.ToLower(c.Action)
+	switch c.Action {
+	case RelabelReplace, RelabelHashMod:
+		if c.TargetLabel == "" {
+			return fmt.Errorf("relabel configuration for %s action requires 'target_label' value", c.Action)
+		}
+		if c.Action == RelabelHashMod && c.Modulus == 0 {
+			return fmt.Errorf("relabel configuration for hashmod action requires 'modulus' greater than 0")
+		}
+	case RelabelKeep, RelabelDrop:
+		if len(c.SourceLabels) == 0 {
+			return fmt.Errorf("relabel configuration for %s action requires 'source_labels' value", c.Action)
+		}
+	case RelabelLabelMap, RelabelLabelDrop, RelabelLabelKeep:
+		if c.Action != RelabelLabelMap && (len(c.SourceLabels) > 0 || c.TargetLabel != "") {
+			return fmt.Errorf("%s action requires only 'regex', and no other fields", c.Action)
+		}
+	default:
+		return fmt.Errorf("unknown relabel action %q", c.Action)
+	}
+	if c.Regex.Regexp == nil {
+		c.Regex = defaultRelabelRegexp
+	}
+	return nil
+}
+
+// RelabelConfigs is an ordered list of relabeling rules
+type RelabelConfigs []*RelabelConfig
+
+// ParseRelabelConfigs parses relabel configs given in yaml or json (e.g. from a service meta)
+func ParseRelabelConfigs(content string) (RelabelConfigs, error) {
+	var configs RelabelConfigs
+	if err := yaml.Unmarshal([]byte(content), &configs); err != nil {
+		return nil, err
+	}
+	return configs, nil
+}
+
+// relabelConfigsCacheSize is the maximum number of relabel configs kept parsed, cache is emptied when it is full
+const relabelConfigsCacheSize = 1000
+
+type parsedRelabelConfigs struct {
+	configs RelabelConfigs
+	err     error
+}
+
+// relabelConfigsCache keeps relabel configs parsed by their content to not parse them from service meta at each scrape
+var relabelConfigsCache = struct {
+	sync.Mutex
+	entries map[string]parsedRelabelConfigs
+}{entries: make(map[string]parsedRelabelConfigs)}
+
+// cachedRelabelConfigs parses relabel configs as ParseRelabelConfigs does but keeps result by content,
+// given relabel configs are shared and must not be modified.
+func cachedRelabelConfigs(content string) (RelabelConfigs, error) {
+	relabelConfigsCache.Lock()
+	defer relabelConfigsCache.Unlock()
+	if parsed, ok := relabelConfigsCache.entries[content]; ok {
+		return parsed.configs, parsed.err
+	}
+	if len(relabelConfigsCache.entries) >= relabelConfigsCacheSize {
+		relabelConfigsCache.entries = make(map[string]parsedRelabelConfigs)
+	}
+	configs, err := ParseRelabelConfigs(content)
+	relabelConfigsCache.entries[content] = parsedRelabelConfigs{configs: configs, err: err}
+	return configs, err
+}
+
+// Process applies relabel configs in order on labels which are modified in place,
+// it returns false if labels must be dropped.
+// Metric name must be given with `__name__` label.
+func (cs RelabelConfigs) Process(labels map[string]string) bool {
+	for _, c := range cs {
+		if !c.process(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *RelabelConfig) process(labels map[string]string) bool {
+	values := make([]string, len(c.SourceLabels))
+	for i, name := range c.SourceLabels {
+		values[i] = labels[name]
+	}
+	val := strings.Join(values, c.Separator)
+
+	switch c.Action {
+	case RelabelDrop:
+		if c.Regex.MatchString(val) {
+			return false
+		}
+	case RelabelKeep:
+		if !c.Regex.MatchString(val) {
+			return false
+		}
+	case RelabelReplace:
+		indexes := c.Regex.FindStringSubmatchIndex(val)
+		if indexes == nil {
+			break
+		}
+		target := string(c.Regex.ExpandString([]byte{}, c.TargetLabel, val, indexes))
+		if !model.LabelName(target).IsValid() {
+			break
+		}
+		res := c.Regex.ExpandString([]byte{}, c.Replacement, val, indexes)
+		if len(res) == 0 {
+			delete(labels, target)
+			break
+		}
+		labels[target] = string(res)
+	case RelabelHashMod:
+		hash := md5.Sum([]byte(val))
+		labels[c.TargetLabel] = fmt.Sprint(binary.BigEndian.Uint64(hash[8:]) % c.Modulus)
+	case RelabelLabelMap:
+		mapped := make(map[string]string)
+		for name, value := range labels {
+			if c.Regex.MatchString(name) {
+				mapped[c.Regex.ReplaceAllString(name, c.Replacement)] = value
+			}
+		}
+		for name, value := range mapped {
+			labels[name] = value
+		}
+	case RelabelLabelDrop:
+		for name := range labels {
+			if c.Regex.MatchString(name) {
+				delete(labels, name)
+			}
+		}
+	case RelabelLabelKeep:
+		for name := range labels {
+			if name != model.MetricNameLabel && !c.Regex.MatchString(name) {
+				delete(labels, name)
+			}
+		}
+	}
+	return true
+}
+
+var invalidLabelCharRe = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// SanitizeLabelName replaces all chars which are not allowed in a label name by `_`,
+// name is also prefixed by `_` if it starts with a digit.
+func SanitizeLabelName(name string) string {
+	name = invalidLabelCharRe.ReplaceAllString(name, "_")
+	if name != "" && name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+// DiscoveryLabels gives labels describing route as prometheus consul service discovery does
+// (e.g. `__meta_consul_service_metadata_<key>`), they can be used as source labels in relabel configs.
+func (r *Route) DiscoveryLabels() map[string]string {
+	labels := map[string]string{
+		"__meta_consul_address":         r.Address,
+		"__meta_consul_dc":              r.Datacenter,
+		"__meta_consul_health":          r.CheckStatus,
+		"__meta_consul_namespace":       r.Namespace,
+		"__meta_consul_partition":       r.Partition,
+		"__meta_consul_node":            r.Node,
+		"__meta_consul_service":         r.ServiceName,
+		"__meta_consul_service_address": r.ServiceAddress,
+		"__meta_consul_service_id":      r.ServiceID,
+		"__meta_consul_service_port":    fmt.Sprint(r.ServicePort),
+		"__meta_consul_tags":            "," + strings.Join(r.ServiceTags, ",") + ",",
+	}
+	for k, v := range r.NodeMeta {
+		labels["__meta_consul_metadata_"+SanitizeLabelName(k)] = v
+	}
+	for k, v := range r.ServiceMeta {
+		labels["__meta_consul_service_metadata_"+SanitizeLabelName(k)] = v
+	}
+	for k, v := range r.TaggedAddresses {
+		labels["__meta_consul_tagged_address_"+SanitizeLabelName(k)] = v
+	}
+	return labels
+}
@@ -0,0 +1,77 @@
+package models_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/orange-cloudfoundry/promconsulfetcher/models"
+)
+
+var _ = Describe("Relabel", func() {
+	process := func(content string, labels map[string]string) bool {
+		configs, err := models.ParseRelabelConfigs(content)
+		Expect(err).ShouldNot(HaveOccurred())
+		return configs.Process(labels)
+	}
+
+	It("replaces with defaults from prometheus", func() {
+		labels := map[string]string{"__name__": "foo", "app": "my-app"}
+		keep := process(`[{source_labels: [app], regex: "my-(.*)", target_label: short_app}]`, labels)
+		Expect(keep).To(BeTrue())
+		Expect(labels).To(HaveKeyWithValue("short_app", "app"))
+	})
+
+	It("keeps and drops series", func() {
+		Expect(process(`[{source_labels: [__name__], regex: "go_.*", action: drop}]`,
+			map[string]string{"__name__": "go_goroutines"})).To(BeFalse())
+		Expect(process(`[{source_labels: [__name__], regex: "go_.*", action: keep}]`,
+			map[string]string{"__name__": "process_cpu"})).To(BeFalse())
+		Expect(process(`[{source_labels: [__name__], regex: "go", action: drop}]`,
+			map[string]string{"__name__": "go_goroutines"})).To(BeTrue())
+	})
+
+	It("maps, drops and keeps labels by name", func() {
+		labels := map[string]string{
+			"__name__":                            "foo",
+			"__meta_consul_service_metadata_team": "ops",
+			"path":                                "/",
+			"id":                                  "1",
+		}
+		keep := process(`
+- action: labelmap
+  regex: __meta_consul_service_metadata_(.+)
+- action: labeldrop
+  regex: path
+- action: labelkeep
+  regex: team|path
+`, labels)
+		Expect(keep).To(BeTrue())
+		Expect(labels).To(Equal(map[string]string{"__name__": "foo", "team": "ops"}))
+	})
+
+	It("sets hash modulus of source labels", func() {
+		labels := map[string]string{"instance": "10.0.0.1:8080"}
+		process(`[{source_labels: [instance], modulus: 4, target_label: shard, action: hashmod}]`, labels)
+		Expect(labels["shard"]).To(BeElementOf("0", "1", "2", "3"))
+	})
+
+	It("rejects invalid relabel configs", func() {
+		_, err := models.ParseRelabelConfigs(`[{action: unknown}]`)
+		Expect(err).Should(HaveOccurred())
+		_, err = models.ParseRelabelConfigs(`[{action: replace}]`)
+		Expect(err).Should(HaveOccurred())
+		_, err = models.ParseRelabelConfigs(`[{action: hashmod, target_label: shard}]`)
+		Expect(err).Should(HaveOccurred())
+	})
+
+	It("gives discovery labels of route", func() {
+		labels := (&models.Route{
+			ServiceName: "my-app",
+			ServiceMeta: map[string]string{"team-name": "ops"},
+			NodeMeta:    map[string]string{"rack": "r1"},
+		}).DiscoveryLabels()
+		Expect(labels).To(HaveKeyWithValue("__meta_consul_service", "my-app"))
+		Expect(labels).To(HaveKeyWithValue("__meta_consul_service_metadata_team_name", "ops"))
+		Expect(labels).To(HaveKeyWithValue("__meta_consul_metadata_rack", "r1"))
+	})
+})
@@ -6,25 +6,54 @@ import (
 	"strings"
 )
 
+const settingsPrefix = "promconsulfetcher"
+
+const (
+	SchemeTagsKey      = settingsPrefix + "." + SchemeSetting
+	MetricPathTagsKey  = settingsPrefix + "." + MetricPathSetting
+	MetricsPortTagsKey = settingsPrefix + "." + MetricsPortSetting
+	TimeoutTagsKey     = settingsPrefix + "." + TimeoutSetting
+	ParamsTagsKey      = settingsPrefix + "." + ParamsSetting
+	DisableTagsKey     = settingsPrefix + "." + DisableSetting
+)
+
 const (
-	SchemeTagsKey     = "promconsulfetcher.scheme"
-	MetricPathTagsKey = "promconsulfetcher.metric_path"
+	// HealthPassing only keeps instances where all checks are passing.
+	HealthPassing = "passing"
+	// HealthWarning keeps instances where checks are passing or warning.
+	HealthWarning = "warning"
+	// HealthAny keeps all instances but still retrieves their checks.
+	HealthAny = "any"
 )
 
+// AllDatacenters is the datacenter to use in query to search in all datacenters known by consul
+const AllDatacenters = "*"
+
+// PreparedQueryPrefix is the prefix to use in query to execute a consul prepared query instead of searching a service
+const PreparedQueryPrefix = "pq:"
+
 const (
-	dcRe          = `(@(?P<dc>[[:word:]\.\-\_]+))?`
+	dcRe          = `(@(?P<dc>\*|[[:word:]\.\-\_]+(,[[:word:]\.\-\_]+)*))?`
 	serviceNameRe = `(?P<name>[[:word:]\-\_]+)`
 	nearRe        = `(~(?P<near>[[:word:]\.\-\_]+))?`
 	tagRe         = `((?P<tag>[[:word:]=:\.\-\_]+)\.)?`
+	// nsPartitionRe follows consul enterprise format `<PARTITION>/<NAMESPACE>/` where partition can be omitted
+	nsPartitionRe = `(((?P<partition>[[:word:]\-\_]+)/)?(?P<ns>[[:word:]\-\_]+)/)?`
 )
 
 var (
 
 	// CatalogServiceQueryRe is the regular expression to use.
-	CatalogServiceQueryRe = regexp.MustCompile(`\A` + tagRe + serviceNameRe + dcRe + nearRe + `\z`)
+	CatalogServiceQueryRe = regexp.MustCompile(`\A` + nsPartitionRe + tagRe + serviceNameRe + dcRe + nearRe + `\z`)
+
+	// PreparedQueryRe is the regular expression to use for prepared query, prefix must be removed before.
+	PreparedQueryRe = regexp.MustCompile(`\A` + nsPartitionRe + serviceNameRe + dcRe + nearRe + `\z`)
 )
 
 func SearchToServiceSearch(search string) (ServiceSearch, error) {
+	if strings.HasPrefix(search, PreparedQueryPrefix) {
+		return searchToPreparedQuery(strings.TrimPrefix(search, PreparedQueryPrefix))
+	}
 	if !CatalogServiceQueryRe.MatchString(search) {
 		return ServiceSearch{}, fmt.Errorf("catalog.service: invalid format: %q", search)
 	}
@@ -34,14 +63,65 @@ func SearchToServiceSearch(search string) (ServiceSearch, error) {
 		Name:       m["name"],
 		Near:       m["near"],
 		Tag:        m["tag"],
+		Namespace:  m["ns"],
+		Partition:  m["partition"],
+	}, nil
+}
+
+// ValidHealth checks that health is a known health filter, empty health is valid and means no health filtering.
+func ValidHealth(health string) error {
+	switch health {
+	case "", HealthPassing, HealthWarning, HealthAny:
+		return nil
+	}
+	return fmt.Errorf("invalid health %q, must be one of %s, %s or %s", health, HealthPassing, HealthWarning, HealthAny)
+}
+
+func searchToPreparedQuery(search string) (ServiceSearch, error) {
+	if !PreparedQueryRe.MatchString(search) {
+		return ServiceSearch{}, fmt.Errorf("prepared_query: invalid format: %q", search)
+	}
+	m := regexpMatch(PreparedQueryRe, search)
+	return ServiceSearch{
+		Datacenter:    m["dc"],
+		Name:          m["name"],
+		Near:          m["near"],
+		Namespace:     m["ns"],
+		Partition:     m["partition"],
+		PreparedQuery: true,
 	}, nil
 }
 
 type ServiceSearch struct {
-	Datacenter string
-	Name       string
-	Near       string
-	Tag        string
+	Datacenter    string
+	Name          string
+	Near          string
+	Tag           string
+	Health        string
+	Namespace     string
+	Partition     string
+	Filter        string
+	PreparedQuery bool
+}
+
+// IsMultiDatacenters returns true when search must be done in multiple datacenters,
+// this is the case when datacenter is AllDatacenters or a comma separated list of datacenters.
+func (s ServiceSearch) IsMultiDatacenters() bool {
+	return s.Datacenter == AllDatacenters || strings.Contains(s.Datacenter, ",")
+}
+
+// Datacenters gives the list of datacenters to search in, it can't resolve AllDatacenters.
+func (s ServiceSearch) Datacenters() []string {
+	if s.Datacenter == "" {
+		return []string{}
+	}
+	return strings.Split(s.Datacenter, ",")
+}
+
+// WithDatacenter returns a copy of search targeting only the given datacenter
+func (s ServiceSearch) WithDatacenter(dc string) ServiceSearch {
+	s.Datacenter = dc
+	return s
 }
 
 func (s ServiceSearch) String() string {
@@ -55,6 +135,15 @@ func (s ServiceSearch) String() string {
 	if s.Near != "" {
 		name = name + "~" + s.Near
 	}
+	if s.Namespace != "" {
+		name = s.Namespace + "/" + name
+	}
+	if s.Partition != "" {
+		name = s.Partition + "/" + name
+	}
+	if s.PreparedQuery {
+		return fmt.Sprintf("prepared_query(%s)", name)
+	}
 	return fmt.Sprintf("catalog.service(%s)", name)
 }
 
@@ -75,24 +164,22 @@ type Route struct {
 	ServiceTags     ServiceTags
 	ServiceMeta     map[string]string
 	ServicePort     int
+	Namespace       string
+	Partition       string
+	CheckStatus     string
+	CheckNames      []string
+	// FailoverDatacenter is the datacenter which answered to a prepared query when it failed over another datacenter
+	FailoverDatacenter string
 }
 
 func (r *Route) FindScheme() string {
-	for _, t := range r.ServiceTags {
-		if strings.HasPrefix(t, SchemeTagsKey+"=") {
-			return strings.TrimPrefix(t, SchemeTagsKey+"=")
-		}
-	}
-	return ""
+	scheme, _ := r.FindSetting(SchemeSetting)
+	return scheme
 }
 
 func (r *Route) FindMetricsPath() string {
-	for _, t := range r.ServiceTags {
-		if strings.HasPrefix(t, MetricPathTagsKey+"=") {
-			return strings.TrimPrefix(t, MetricPathTagsKey+"=")
-		}
-	}
-	return ""
+	metricPath, _ := r.FindSetting(MetricPathSetting)
+	return metricPath
 }
 
 // regexpMatch matches the given regexp and extracts the match groups into a
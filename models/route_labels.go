@@ -0,0 +1,129 @@
+package models
+
+import (
+	"fmt"
+
+	"github.com/prometheus/common/model"
+)
+
+const (
+	// LabelConflictOverwrite replaces app label by the injected one
+	LabelConflictOverwrite = "overwrite"
+	// LabelConflictKeep keeps app label and does not inject ours, as prometheus `honor_labels: true`
+	LabelConflictKeep = "keep"
+	// LabelConflictRename renames app label to `exported_<name>` and injects ours, as prometheus `honor_labels: false`
+	LabelConflictRename = "rename"
+
+	ExportedLabelPrefix = "exported_"
+)
+
+// RouteLabelNames are default names of labels injected from route on metrics
+var RouteLabelNames = []string{
+	"node_name",
+	"node_id",
+	"node_address",
+	"datacenter",
+	"service_name",
+	"service_id",
+	"service_address",
+	"service_port",
+	"namespace",
+	"partition",
+	"failover_datacenter",
+	"metrics_port",
+	"check_status",
+	"check_names",
+}
+
+// OptInRouteLabelNames are route labels only injected when included in configuration,
+// their value changes with instance health which would create new series at each change.
+var OptInRouteLabelNames = []string{
+	"check_status",
+	"check_names",
+}
+
+// RouteLabelsConfig sets names of labels injected from route and how to handle conflicts with app labels
+type RouteLabelsConfig struct {
+	// Prefix is added to all injected labels not renamed (e.g. `consul_` gives `consul_service_name`)
+	Prefix string `yaml:"prefix"`
+	// Names renames injected labels, key is default label name (e.g. `node_name: node`)
+	Names map[string]string `yaml:"names"`
+	// Exclude lists default names of labels to not inject
+	Exclude []string `yaml:"exclude"`
+	// Include lists default names of opt-in labels to inject (see OptInRouteLabelNames)
+	Include []string `yaml:"include"`
+	// Conflict is strategy when app gives a label with the same name, one of overwrite (default), keep or rename
+	Conflict string `yaml:"conflict"`
+}
+
+func (c *RouteLabelsConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain RouteLabelsConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	if c.Prefix != "" && !model.LabelName(c.Prefix).IsValid() {
+		return fmt.Errorf("invalid route labels prefix %q", c.Prefix)
+	}
+	for name, newName := range c.Names {
+		if !isRouteLabelName(name) {
+			return fmt.Errorf("unknown route label %q, must be one of %v", name, RouteLabelNames)
+		}
+		if !model.LabelName(newName).IsValid() {
+			return fmt.Errorf("invalid label name %q for route label %s", newName, name)
+		}
+	}
+	for _, name := range c.Exclude {
+		if !isRouteLabelName(name) {
+			return fmt.Errorf("unknown route label %q, must be one of %v", name, RouteLabelNames)
+		}
+	}
+	for _, name := range c.Include {
+		if !contains(OptInRouteLabelNames, name) {
+			return fmt.Errorf("route label %q can't be included, must be one of %v", name, OptInRouteLabelNames)
+		}
+	}
+	switch c.Conflict {
+	case "", LabelConflictOverwrite, LabelConflictKeep, LabelConflictRename:
+	default:
+		return fmt.Errorf(
+			"invalid labels conflict strategy %q, must be one of %s, %s or %s",
+			c.Conflict, LabelConflictOverwrite, LabelConflictKeep, LabelConflictRename,
+		)
+	}
+	return nil
+}
+
+// Name gives name to use for route label with given default name, false is returned when label must not be injected
+func (c RouteLabelsConfig) Name(name string) (string, bool) {
+	if contains(c.Exclude, name) {
+		return "", false
+	}
+	if contains(OptInRouteLabelNames, name) && !contains(c.Include, name) {
+		return "", false
+	}
+	if newName, ok := c.Names[name]; ok {
+		return newName, true
+	}
+	return c.Prefix + name, true
+}
+
+// ConflictStrategy gives strategy to use on labels conflict, overwrite is the default
+func (c RouteLabelsConfig) ConflictStrategy() string {
+	if c.Conflict == "" {
+		return LabelConflictOverwrite
+	}
+	return c.Conflict
+}
+
+func isRouteLabelName(name string) bool {
+	return contains(RouteLabelNames, name)
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+package models_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/yaml.v2"
+
+	"github.com/orange-cloudfoundry/promconsulfetcher/models"
+)
+
+var _ = Describe("RouteLabelsConfig", func() {
+	It("gives configured names with prefix, renames and exclusions", func() {
+		var c models.RouteLabelsConfig
+		err := yaml.Unmarshal([]byte(`
+prefix: consul_
+names: {node_name: node}
+exclude: [node_id]
+conflict: rename
+`), &c)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		name, ok := c.Name("service_name")
+		Expect(ok).To(BeTrue())
+		Expect(name).To(Equal("consul_service_name"))
+		name, ok = c.Name("node_name")
+		Expect(ok).To(BeTrue())
+		Expect(name).To(Equal("node"))
+		_, ok = c.Name("node_id")
+		Expect(ok).To(BeFalse())
+		Expect(c.ConflictStrategy()).To(Equal(models.LabelConflictRename))
+	})
+
+	It("injects opt-in labels only when included", func() {
+		var c models.RouteLabelsConfig
+		Expect(yaml.Unmarshal([]byte(`{names: {check_status: health}}`), &c)).ShouldNot(HaveOccurred())
+		_, ok := c.Name("check_status")
+		Expect(ok).To(BeFalse())
+		_, ok = c.Name("check_names")
+		Expect(ok).To(BeFalse())
+
+		Expect(yaml.Unmarshal([]byte(`{names: {check_status: health}, include: [check_status]}`), &c)).ShouldNot(HaveOccurred())
+		name, ok := c.Name("check_status")
+		Expect(ok).To(BeTrue())
+		Expect(name).To(Equal("health"))
+		_, ok = c.Name("check_names")
+		Expect(ok).To(BeFalse())
+	})
+
+	It("overwrites by default", func() {
+		Expect(models.RouteLabelsConfig{}.ConflictStrategy()).To(Equal(models.LabelConflictOverwrite))
+	})
+
+	It("rejects unknown labels and strategies", func() {
+		var c models.RouteLabelsConfig
+		Expect(yaml.Unmarshal([]byte(`{names: {unknown: foo}}`), &c)).Should(HaveOccurred())
+		Expect(yaml.Unmarshal([]byte(`{exclude: [unknown]}`), &c)).Should(HaveOccurred())
+		Expect(yaml.Unmarshal([]byte(`{include: [service_name]}`), &c)).Should(HaveOccurred())
+		Expect(yaml.Unmarshal([]byte(`{conflict: merge}`), &c)).Should(HaveOccurred())
+	})
+})
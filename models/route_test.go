@@ -0,0 +1,79 @@
+package models_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/orange-cloudfoundry/promconsulfetcher/models"
+)
+
+var _ = Describe("Route", func() {
+	Context("SearchToServiceSearch", func() {
+		It("parses a full consul template style query", func() {
+			search, err := models.SearchToServiceSearch("mytag.myservice@dc1~_agent")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(search).To(Equal(models.ServiceSearch{
+				Datacenter: "dc1",
+				Name:       "myservice",
+				Near:       "_agent",
+				Tag:        "mytag",
+			}))
+		})
+
+		It("parses namespace and partition", func() {
+			search, err := models.SearchToServiceSearch("mypartition/myns/mytag.myservice@dc1")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(search.Partition).To(Equal("mypartition"))
+			Expect(search.Namespace).To(Equal("myns"))
+			Expect(search.Tag).To(Equal("mytag"))
+			Expect(search.Name).To(Equal("myservice"))
+
+			search, err = models.SearchToServiceSearch("myns/myservice")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(search.Partition).To(BeEmpty())
+			Expect(search.Namespace).To(Equal("myns"))
+			Expect(search.Name).To(Equal("myservice"))
+		})
+
+		It("parses multiple datacenters", func() {
+			search, err := models.SearchToServiceSearch("myservice@dc1,dc2")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(search.IsMultiDatacenters()).To(BeTrue())
+			Expect(search.Datacenters()).To(Equal([]string{"dc1", "dc2"}))
+			Expect(search.WithDatacenter("dc2").IsMultiDatacenters()).To(BeFalse())
+
+			search, err = models.SearchToServiceSearch("myservice@*")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(search.Datacenter).To(Equal(models.AllDatacenters))
+			Expect(search.IsMultiDatacenters()).To(BeTrue())
+		})
+
+		It("parses a prepared query", func() {
+			search, err := models.SearchToServiceSearch("pq:my-query@dc1")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(search).To(Equal(models.ServiceSearch{
+				Datacenter:    "dc1",
+				Name:          "my-query",
+				PreparedQuery: true,
+			}))
+			Expect(search.String()).To(Equal("prepared_query(my-query@dc1)"))
+		})
+
+		It("returns an error on invalid query", func() {
+			_, err := models.SearchToServiceSearch("my service")
+			Expect(err).Should(HaveOccurred())
+		})
+	})
+
+	Context("ValidHealth", func() {
+		It("accepts known health and empty one", func() {
+			for _, health := range []string{"", models.HealthPassing, models.HealthWarning, models.HealthAny} {
+				Expect(models.ValidHealth(health)).ShouldNot(HaveOccurred())
+			}
+		})
+
+		It("rejects unknown health", func() {
+			Expect(models.ValidHealth("critical")).Should(HaveOccurred())
+		})
+	})
+})
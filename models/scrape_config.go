@@ -0,0 +1,122 @@
+package models
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SchemeSetting      = "scheme"
+	MetricPathSetting  = "metric_path"
+	MetricsPortSetting = "metrics_port"
+	TimeoutSetting     = "timeout"
+	ParamsSetting      = "params"
+	DisableSetting     = "disable"
+	// RelabelConfigsSetting is a list of relabel configs in yaml or json
+	RelabelConfigsSetting = "relabel_configs"
+	// TLSServerNameSetting is the server name (SNI) to use when scraping instance over tls
+	TLSServerNameSetting = "tls_server_name"
+	// TLSProfileSetting is the name of the backend tls profile from configuration to use when scraping instance
+	TLSProfileSetting = "tls_profile"
+)
+
+// SettingTagsKey gives service tag key for a setting (e.g. `promconsulfetcher.metric_path`)
+func SettingTagsKey(setting string) string {
+	return settingsPrefix + "." + setting
+}
+
+// SettingMetaKey gives service meta key for a setting,
+// consul only allows alphanumeric, `-` and `_` chars in meta keys so `.` from tag key is replaced by `_`
+// (e.g. `promconsulfetcher.metric_path` tag is `promconsulfetcher_metric_path` in meta).
+func SettingMetaKey(setting string) string {
+	return settingsPrefix + "_" + setting
+}
+
+// ScrapeConfig is the configuration used to scrape a route
+type ScrapeConfig struct {
+	Scheme        string
+	MetricPath    string
+	MetricsPort   int
+	Timeout       time.Duration
+	Params        url.Values
+	AddressPolicy AddressPolicy
+	Disabled      bool
+	// RelabelConfigs are relabel configs set by service, they are applied on scraped metrics before global ones
+	RelabelConfigs RelabelConfigs
+}
+
+// FindSetting gives value of a setting for route, order of precedence is service meta and then service tags.
+func (r *Route) FindSetting(setting string) (string, bool) {
+	if value, ok := r.ServiceMeta[SettingMetaKey(setting)]; ok {
+		return value, true
+	}
+	tagKey := SettingTagsKey(setting) + "="
+	for _, t := range r.ServiceTags {
+		if strings.HasPrefix(t, tagKey) {
+			return strings.TrimPrefix(t, tagKey), true
+		}
+	}
+	return "", false
+}
+
+// IsScrapeDisabled returns true when instance opted out from scraping
+func (r *Route) IsScrapeDisabled() bool {
+	value, ok := r.FindSetting(DisableSetting)
+	if !ok {
+		return false
+	}
+	disabled, err := strconv.ParseBool(value)
+	return err == nil && disabled
+}
+
+// ScrapeConfig gives configuration to scrape route, settings are taken by order of precedence from
+// service meta, service tags and then defaults (which are given by request params or default values).
+func (r *Route) ScrapeConfig(defaults ScrapeConfig) (ScrapeConfig, error) {
+	sc := defaults
+	if value, ok := r.FindSetting(SchemeSetting); ok && value != "" {
+		sc.Scheme = value
+	}
+	if value, ok := r.FindSetting(MetricPathSetting); ok && value != "" {
+		sc.MetricPath = value
+	}
+	if value, ok := r.FindSetting(MetricsPortSetting); ok && value != "" {
+		port, err := strconv.Atoi(value)
+		if err != nil || port <= 0 || port > 65535 {
+			return sc, fmt.Errorf("invalid %s setting %q: must be a valid port", MetricsPortSetting, value)
+		}
+		sc.MetricsPort = port
+	}
+	if value, ok := r.FindSetting(TimeoutSetting); ok && value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			return sc, fmt.Errorf("invalid %s setting %q: %s", TimeoutSetting, value, err.Error())
+		}
+		sc.Timeout = timeout
+	}
+	if value, ok := r.FindSetting(ParamsSetting); ok && value != "" {
+		params, err := url.ParseQuery(value)
+		if err != nil {
+			return sc, fmt.Errorf("invalid %s setting %q: %s", ParamsSetting, value, err.Error())
+		}
+		merged := make(url.Values)
+		for k, v := range defaults.Params {
+			merged[k] = v
+		}
+		for k, v := range params {
+			merged[k] = v
+		}
+		sc.Params = merged
+	}
+	if value, ok := r.FindSetting(RelabelConfigsSetting); ok && value != "" {
+		relabelConfigs, err := cachedRelabelConfigs(value)
+		if err != nil {
+			return sc, fmt.Errorf("invalid %s setting: %s", RelabelConfigsSetting, err.Error())
+		}
+		sc.RelabelConfigs = relabelConfigs
+	}
+	sc.Disabled = r.IsScrapeDisabled()
+	return sc, nil
+}
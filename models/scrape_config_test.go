@@ -0,0 +1,89 @@
+package models_test
+
+import (
+	"net/url"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/orange-cloudfoundry/promconsulfetcher/models"
+)
+
+var _ = Describe("ScrapeConfig", func() {
+	defaults := models.ScrapeConfig{
+		Scheme:     "http",
+		MetricPath: "/metrics",
+		Params:     url.Values{"foo": []string{"bar"}},
+	}
+
+	It("uses defaults when route has no settings", func() {
+		sc, err := (&models.Route{}).ScrapeConfig(defaults)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(sc).To(Equal(defaults))
+	})
+
+	It("takes settings from service meta over service tags over defaults", func() {
+		route := &models.Route{
+			ServiceTags: models.ServiceTags{
+				"promconsulfetcher.scheme=https",
+				"promconsulfetcher.metric_path=/tag-metrics",
+				"promconsulfetcher.timeout=5s",
+			},
+			ServiceMeta: map[string]string{
+				"promconsulfetcher_metric_path":  "/meta-metrics",
+				"promconsulfetcher_metrics_port": "9100",
+				"promconsulfetcher_params":       "module=http_2xx&foo=baz",
+			},
+		}
+		sc, err := route.ScrapeConfig(defaults)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(sc.Scheme).To(Equal("https"))
+		Expect(sc.MetricPath).To(Equal("/meta-metrics"))
+		Expect(sc.MetricsPort).To(Equal(9100))
+		Expect(sc.Timeout).To(Equal(5 * time.Second))
+		Expect(sc.Params).To(Equal(url.Values{"foo": []string{"baz"}, "module": []string{"http_2xx"}}))
+		Expect(sc.Disabled).To(BeFalse())
+	})
+
+	It("gives an error on invalid setting", func() {
+		route := &models.Route{
+			ServiceMeta: map[string]string{"promconsulfetcher_metrics_port": "notaport"},
+		}
+		_, err := route.ScrapeConfig(defaults)
+		Expect(err).Should(HaveOccurred())
+	})
+
+	It("parses relabel configs of service meta once", func() {
+		route := &models.Route{
+			ServiceMeta: map[string]string{
+				"promconsulfetcher_relabel_configs": `[{source_labels: [__name__], regex: "go_.*", action: drop}]`,
+			},
+		}
+		sc, err := route.ScrapeConfig(defaults)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(sc.RelabelConfigs).To(HaveLen(1))
+		Expect(sc.RelabelConfigs[0].Action).To(Equal(models.RelabelDrop))
+
+		other, err := (&models.Route{ServiceMeta: route.ServiceMeta}).ScrapeConfig(defaults)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(other.RelabelConfigs[0]).To(BeIdenticalTo(sc.RelabelConfigs[0]))
+	})
+
+	It("gives an error on invalid relabel configs each time", func() {
+		route := &models.Route{
+			ServiceMeta: map[string]string{"promconsulfetcher_relabel_configs": `[{action: unknown}]`},
+		}
+		_, err := route.ScrapeConfig(defaults)
+		Expect(err).Should(HaveOccurred())
+		_, err = route.ScrapeConfig(defaults)
+		Expect(err).Should(HaveOccurred())
+	})
+
+	It("lets instance opt out from scraping", func() {
+		route := &models.Route{
+			ServiceMeta: map[string]string{"promconsulfetcher_disable": "true"},
+		}
+		Expect(route.IsScrapeDisabled()).To(BeTrue())
+	})
+})
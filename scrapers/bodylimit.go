@@ -0,0 +1,65 @@
+package scrapers
+
+import (
+	"fmt"
+	"io"
+)
+
+const (
+	BodySizeLimit           = "body_size_limit"
+	CompressedBodySizeLimit = "compressed_body_size_limit"
+)
+
+// BodySizeLimitError is given when a response body is bigger than a limit
+type BodySizeLimitError struct {
+	Limit string
+	// MaxBytes is the maximum size of body allowed by limit
+	MaxBytes int64
+}
+
+func (e *BodySizeLimitError) Error() string {
+	return fmt.Sprintf("%s exceeded: body is bigger than %d bytes", e.Limit, e.MaxBytes)
+}
+
+// sizeLimitReader fails reading when more than maxBytes bytes are read, 0 means no limit
+type sizeLimitReader struct {
+	reader   io.Reader
+	limit    string
+	maxBytes int64
+	read     int64
+	err      *BodySizeLimitError
+}
+
+func newSizeLimitReader(reader io.Reader, limit string, maxBytes int64) *sizeLimitReader {
+	return &sizeLimitReader{reader: reader, limit: limit, maxBytes: maxBytes}
+}
+
+func (r *sizeLimitReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	if r.maxBytes <= 0 {
+		return r.reader.Read(p)
+	}
+	// read one more byte than allowed to know if limit is exceeded
+	if remaining := r.maxBytes + 1 - r.read; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := r.reader.Read(p)
+	r.read += int64(n)
+	if r.read > r.maxBytes {
+		r.err = &BodySizeLimitError{Limit: r.limit, MaxBytes: r.maxBytes}
+		return n - int(r.read-r.maxBytes), r.err
+	}
+	return n, err
+}
+
+// limitedReadCloser reads from a size limited reader and closes underlying reader
+type limitedReadCloser struct {
+	*sizeLimitReader
+	closer io.Closer
+}
+
+func (r limitedReadCloser) Close() error {
+	return r.closer.Close()
+}
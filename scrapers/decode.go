@@ -0,0 +1,152 @@
+package scrapers
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+	"google.golang.org/protobuf/proto"
+)
+
+// Response is the body of a scrape with the content type given by the backend,
+// reading body fails when it exceeds body size limits.
+type Response struct {
+	io.ReadCloser
+	ContentType string
+
+	limitReaders []*sizeLimitReader
+}
+
+// SizeLimitError gives error of the body size limit exceeded while reading body, nil if none was exceeded
+func (r *Response) SizeLimitError() *BodySizeLimitError {
+	for _, limitReader := range r.limitReaders {
+		if limitReader.err != nil {
+			return limitReader.err
+		}
+	}
+	return nil
+}
+
+// Decode parses metrics from reader with a decoder chosen by content type,
+// text, openmetrics and delimited protobuf are supported, text format is used when content type is unknown.
+// Gauge histograms are converted to gauges as they can't be written in text formats.
+func Decode(r io.Reader, contentType string) (map[string]*dto.MetricFamily, error) {
+	var metricsGroup map[string]*dto.MetricFamily
+	var err error
+	mediatype, _, parseErr := mime.ParseMediaType(contentType)
+	format := expfmt.ResponseFormat(http.Header{"Content-Type": []string{contentType}})
+	switch {
+	case parseErr == nil && mediatype == expfmt.OpenMetricsType:
+		metricsGroup, err = ParseOpenMetrics(r)
+	case format.FormatType() == expfmt.TypeProtoDelim:
+		metricsGroup, err = decodeProtobuf(r, format)
+	default:
+		parser := &expfmt.TextParser{}
+		metricsGroup, err = parser.TextToMetricFamilies(r)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return metricsGroup, convertGaugeHistograms(metricsGroup)
+}
+
+// convertGaugeHistograms replaces each gauge histogram by gauge families for its buckets, count and sum
+// named with `_bucket`, `_gcount` and `_gsum` suffixes as exposed in openmetrics, exemplars are dropped.
+func convertGaugeHistograms(metricsGroup map[string]*dto.MetricFamily) error {
+	for name, mf := range metricsGroup {
+		if mf.GetType() != dto.MetricType_GAUGE_HISTOGRAM {
+			continue
+		}
+		delete(metricsGroup, name)
+		bucketMf := newGaugeFamily(name+"_bucket", mf)
+		countMf := newGaugeFamily(name+"_gcount", mf)
+		sumMf := newGaugeFamily(name+"_gsum", mf)
+		for _, metric := range mf.Metric {
+			histogram := metric.GetHistogram()
+			for _, bucket := range histogram.GetBucket() {
+				// label pairs are shared with the original metric, a new slice is made to add le label
+				labels := make([]*dto.LabelPair, 0, len(metric.Label)+1)
+				labels = append(labels, metric.Label...)
+				labels = append(labels, &dto.LabelPair{
+					Name:  proto.String(model.BucketLabel),
+					Value: proto.String(formatBucketBound(bucket.GetUpperBound())),
+				})
+				bucketMf.Metric = append(bucketMf.Metric, gaugeMetric(metric, labels, float64(bucket.GetCumulativeCount())))
+			}
+			if histogram.SampleCount != nil {
+				countMf.Metric = append(countMf.Metric, gaugeMetric(metric, metric.Label, float64(histogram.GetSampleCount())))
+			}
+			if histogram.SampleSum != nil {
+				sumMf.Metric = append(sumMf.Metric, gaugeMetric(metric, metric.Label, histogram.GetSampleSum()))
+			}
+		}
+		for _, gaugeMf := range []*dto.MetricFamily{bucketMf, countMf, sumMf} {
+			if len(gaugeMf.Metric) == 0 {
+				continue
+			}
+			if _, ok := metricsGroup[gaugeMf.GetName()]; ok {
+				return fmt.Errorf("metric family %s of gauge histogram %s already exists", gaugeMf.GetName(), name)
+			}
+			metricsGroup[gaugeMf.GetName()] = gaugeMf
+		}
+	}
+	return nil
+}
+
+func newGaugeFamily(name string, mf *dto.MetricFamily) *dto.MetricFamily {
+	return &dto.MetricFamily{
+		Name: proto.String(name),
+		Help: mf.Help,
+		Type: dto.MetricType_GAUGE.Enum(),
+	}
+}
+
+func gaugeMetric(metric *dto.Metric, labels []*dto.LabelPair, value float64) *dto.Metric {
+	return &dto.Metric{
+		Label:       labels,
+		Gauge:       &dto.Gauge{Value: proto.Float64(value)},
+		TimestampMs: metric.TimestampMs,
+	}
+}
+
+// formatBucketBound formats bucket upper bound as expfmt does for le label
+func formatBucketBound(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func decodeProtobuf(r io.Reader, format expfmt.Format) (map[string]*dto.MetricFamily, error) {
+	// decoder reads through a new bufio.Reader at each call which would drop what it buffered past
+	// the first message, reading through a bufio.Reader makes it reused instead
+	decoder := expfmt.NewDecoder(bufio.NewReader(r), format)
+	metricsGroup := make(map[string]*dto.MetricFamily)
+	for {
+		mf := &dto.MetricFamily{}
+		err := decoder.Decode(mf)
+		if err == io.EOF {
+			return metricsGroup, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error decoding protobuf metrics: %s", err.Error())
+		}
+		if existing, ok := metricsGroup[mf.GetName()]; ok {
+			existing.Metric = append(existing.Metric, mf.Metric...)
+			continue
+		}
+		metricsGroup[mf.GetName()] = mf
+	}
+}
@@ -0,0 +1,248 @@
+package scrapers_test
+
+import (
+	"bytes"
+	"math"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+
+	"github.com/orange-cloudfoundry/promconsulfetcher/scrapers"
+)
+
+var _ = Describe("Decode", func() {
+	Context("text format", func() {
+		It("parses text when content type is text or unknown", func() {
+			for _, contentType := range []string{"text/plain; version=0.0.4", ""} {
+				metrics, err := scrapers.Decode(strings.NewReader("# TYPE foo counter\nfoo 1\n"), contentType)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(metrics).To(HaveKey("foo"))
+				Expect(metrics["foo"].Metric[0].Counter.GetValue()).To(Equal(float64(1)))
+			}
+		})
+	})
+
+	Context("protobuf format", func() {
+		It("parses delimited protobuf", func() {
+			buf := &bytes.Buffer{}
+			format := expfmt.NewFormat(expfmt.TypeProtoDelim)
+			enc := expfmt.NewEncoder(buf, format)
+			err := enc.Encode(&dto.MetricFamily{
+				Name: strPtr("foo_total"),
+				Type: dto.MetricType_COUNTER.Enum(),
+				Metric: []*dto.Metric{
+					{Counter: &dto.Counter{Value: floatPtr(3)}},
+				},
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			metrics, err := scrapers.Decode(buf, string(format))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(metrics).To(HaveKey("foo_total"))
+			Expect(metrics["foo_total"].Metric[0].Counter.GetValue()).To(Equal(float64(3)))
+		})
+
+		It("parses all families of delimited protobuf", func() {
+			buf := &bytes.Buffer{}
+			format := expfmt.NewFormat(expfmt.TypeProtoDelim)
+			enc := expfmt.NewEncoder(buf, format)
+			for _, name := range []string{"foo", "bar", "baz"} {
+				Expect(enc.Encode(&dto.MetricFamily{
+					Name:   strPtr(name),
+					Type:   dto.MetricType_GAUGE.Enum(),
+					Metric: []*dto.Metric{{Gauge: &dto.Gauge{Value: floatPtr(1)}}},
+				})).To(Succeed())
+			}
+
+			metrics, err := scrapers.Decode(bytes.NewReader(buf.Bytes()), string(format))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(metrics).To(HaveLen(3))
+			Expect(metrics).To(HaveKey("baz"))
+		})
+	})
+
+	Context("openmetrics format", func() {
+		contentType := "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+		It("keeps exemplars, units and created timestamps", func() {
+			content := `# TYPE http_requests counter
+# HELP http_requests Requests \"served\".
+http_requests_total{code="200"} 10 # {trace_id="abc"} 1.0 1520879607.789
+http_requests_created{code="200"} 1520872607.123
+# TYPE request_duration_seconds histogram
+# UNIT request_duration_seconds seconds
+request_duration_seconds_bucket{le="0.5"} 2
+request_duration_seconds_bucket{le="+Inf"} 3 # {trace_id="def"} 0.7
+request_duration_seconds_count 3
+request_duration_seconds_sum 1.2
+request_duration_seconds_created 1520872607
+# TYPE build info
+build_info{version="1.0"} 1
+# EOF
+`
+			metrics, err := scrapers.Decode(strings.NewReader(content), contentType)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(metrics).To(HaveLen(3))
+
+			counter := metrics["http_requests_total"]
+			Expect(counter).ToNot(BeNil())
+			Expect(counter.GetType()).To(Equal(dto.MetricType_COUNTER))
+			Expect(counter.GetHelp()).To(Equal(`Requests "served".`))
+			Expect(counter.Metric).To(HaveLen(1))
+			Expect(counter.Metric[0].Label[0].GetValue()).To(Equal("200"))
+			Expect(counter.Metric[0].Counter.GetValue()).To(Equal(float64(10)))
+			Expect(counter.Metric[0].Counter.Exemplar.Label[0].GetValue()).To(Equal("abc"))
+			Expect(counter.Metric[0].Counter.Exemplar.Timestamp.GetSeconds()).To(Equal(int64(1520879607)))
+			Expect(counter.Metric[0].Counter.CreatedTimestamp.GetSeconds()).To(Equal(int64(1520872607)))
+
+			histogram := metrics["request_duration_seconds"]
+			Expect(histogram).ToNot(BeNil())
+			Expect(histogram.GetType()).To(Equal(dto.MetricType_HISTOGRAM))
+			Expect(histogram.GetUnit()).To(Equal("seconds"))
+			Expect(histogram.Metric).To(HaveLen(1))
+			Expect(histogram.Metric[0].Label).To(BeEmpty())
+			Expect(histogram.Metric[0].Histogram.GetSampleCount()).To(Equal(uint64(3)))
+			Expect(histogram.Metric[0].Histogram.GetSampleSum()).To(Equal(1.2))
+			Expect(histogram.Metric[0].Histogram.Bucket).To(HaveLen(2))
+			Expect(histogram.Metric[0].Histogram.Bucket[1].Exemplar.GetValue()).To(Equal(0.7))
+			Expect(histogram.Metric[0].Histogram.CreatedTimestamp.GetSeconds()).To(Equal(int64(1520872607)))
+
+			info := metrics["build_info"]
+			Expect(info).ToNot(BeNil())
+			Expect(info.GetType()).To(Equal(dto.MetricType_GAUGE))
+		})
+
+		It("fails when # EOF is missing", func() {
+			_, err := scrapers.Decode(strings.NewReader("foo 1\n"), contentType)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("fails when a metric family is not contiguous", func() {
+			_, err := scrapers.Decode(strings.NewReader("foo 1\nbar 1\nfoo{a=\"b\"} 2\n# EOF\n"), contentType)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("accepts infinity and nan spelled as in specification", func() {
+			content := "# TYPE foo gauge\nfoo{a=\"1\"} +Inf\nfoo{a=\"2\"} -Inf\nfoo{a=\"3\"} NaN\nfoo{a=\"4\",b=\"5\"} 1.5e3\n# EOF\n"
+			metrics, err := scrapers.Decode(strings.NewReader(content), contentType)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(metrics["foo"].Metric).To(HaveLen(4))
+			Expect(metrics["foo"].Metric[3].Label).To(HaveLen(2))
+			Expect(metrics["foo"].Metric[3].Gauge.GetValue()).To(Equal(1500.0))
+		})
+
+		for _, invalid := range []struct {
+			description string
+			content     string
+		}{
+			{"labels are not separated by a comma", `foo{a="1" b="2"} 1`},
+			{"labels are not separated at all", `foo{a="1"b="2"} 1`},
+			{"label set ends with a comma", `foo{a="1",} 1`},
+			{"value is spelled inf", "foo inf"},
+			{"value is spelled Inf without sign", "foo Inf"},
+			{"value is spelled infinity", "foo +infinity"},
+			{"value is spelled nan", "foo nan"},
+			{"bucket bound is spelled inf", "# TYPE foo histogram\nfoo_bucket{le=\"inf\"} 1\nfoo_count 1\nfoo_sum 1"},
+			{"value is hexadecimal", "foo 0x1"},
+			{"counter has only a created sample", "# TYPE foo counter\nfoo_created 1520872607\nbar 1"},
+			{"last counter has only a created sample", "# TYPE foo counter\nfoo_created 1520872607"},
+			{"counter series has only a created sample", "# TYPE foo counter\nfoo_total{a=\"1\"} 1\nfoo_created{a=\"2\"} 1520872607"},
+			{"summary has only a created sample", "# TYPE foo summary\nfoo_created 1520872607"},
+		} {
+			invalid := invalid
+			It("fails when "+invalid.description, func() {
+				_, err := scrapers.Decode(strings.NewReader(invalid.content+"\n# EOF\n"), contentType)
+				Expect(err).To(HaveOccurred())
+			})
+		}
+
+		It("accepts created sample before the samples it applies to", func() {
+			content := "# TYPE foo counter\nfoo_created 1520872607\nfoo_total 1\n# EOF\n"
+			metrics, err := scrapers.Decode(strings.NewReader(content), contentType)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(metrics["foo_total"].Metric[0].Counter.GetValue()).To(Equal(1.0))
+			Expect(metrics["foo_total"].Metric[0].Counter.CreatedTimestamp.GetSeconds()).To(Equal(int64(1520872607)))
+		})
+
+		It("converts gauge histograms to gauges which can be encoded in every format", func() {
+			content := `# TYPE queue_size gaugehistogram
+# HELP queue_size Queue size.
+queue_size_bucket{queue="a",le="1"} 2 # {trace_id="abc"} 0.5
+queue_size_bucket{queue="a",le="+Inf"} 3
+queue_size_gcount{queue="a"} 3
+queue_size_gsum{queue="a"} 4.5
+# EOF
+`
+			metrics, err := scrapers.Decode(strings.NewReader(content), contentType)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(metrics).ToNot(HaveKey("queue_size"))
+			expectGaugeHistogramConverted(metrics)
+		})
+	})
+
+	It("converts gauge histograms given in protobuf to gauges which can be encoded in every format", func() {
+		buf := &bytes.Buffer{}
+		format := expfmt.NewFormat(expfmt.TypeProtoDelim)
+		err := expfmt.NewEncoder(buf, format).Encode(&dto.MetricFamily{
+			Name: strPtr("queue_size"),
+			Help: strPtr("Queue size."),
+			Type: dto.MetricType_GAUGE_HISTOGRAM.Enum(),
+			Metric: []*dto.Metric{{
+				Label: []*dto.LabelPair{{Name: strPtr("queue"), Value: strPtr("a")}},
+				Histogram: &dto.Histogram{
+					SampleCount: uintPtr(3),
+					SampleSum:   floatPtr(4.5),
+					Bucket: []*dto.Bucket{
+						{UpperBound: floatPtr(1), CumulativeCount: uintPtr(2)},
+						{UpperBound: floatPtr(math.Inf(1)), CumulativeCount: uintPtr(3)},
+					},
+				},
+			}},
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		metrics, err := scrapers.Decode(buf, string(format))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(metrics).ToNot(HaveKey("queue_size"))
+		expectGaugeHistogramConverted(metrics)
+	})
+})
+
+func expectGaugeHistogramConverted(metrics map[string]*dto.MetricFamily) {
+	Expect(metrics).To(HaveLen(3))
+	Expect(metrics["queue_size_bucket"].GetType()).To(Equal(dto.MetricType_GAUGE))
+	Expect(metrics["queue_size_bucket"].GetHelp()).To(Equal("Queue size."))
+	Expect(metrics["queue_size_bucket"].Metric).To(HaveLen(2))
+	Expect(metrics["queue_size_bucket"].Metric[1].Label).To(HaveLen(2))
+	Expect(metrics["queue_size_bucket"].Metric[1].Label[1].GetValue()).To(Equal("+Inf"))
+	Expect(metrics["queue_size_bucket"].Metric[1].Gauge.GetValue()).To(Equal(3.0))
+	Expect(metrics["queue_size_gcount"].Metric[0].Gauge.GetValue()).To(Equal(3.0))
+	Expect(metrics["queue_size_gsum"].Metric[0].Gauge.GetValue()).To(Equal(4.5))
+
+	for _, formatType := range []expfmt.FormatType{expfmt.TypeTextPlain, expfmt.TypeOpenMetrics, expfmt.TypeProtoDelim} {
+		buf := &bytes.Buffer{}
+		enc := expfmt.NewEncoder(buf, expfmt.NewFormat(formatType))
+		for _, mf := range metrics {
+			Expect(enc.Encode(mf)).To(Succeed())
+		}
+	}
+	buf := &bytes.Buffer{}
+	enc := expfmt.NewEncoder(buf, expfmt.NewFormat(expfmt.TypeTextPlain))
+	Expect(enc.Encode(metrics["queue_size_bucket"])).To(Succeed())
+	Expect(buf.String()).To(ContainSubstring(`queue_size_bucket{queue="a",le="1"} 2`))
+}
+
+func strPtr(v string) *string {
+	return &v
+}
+
+func floatPtr(v float64) *float64 {
+	return &v
+}
+
+func uintPtr(v uint64) *uint64 {
+	return &v
+}
@@ -0,0 +1,79 @@
+package scrapers
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// hostLimiter limits number of connections in flight per host, a limiter with max 0 has no limit.
+// A host is forgotten when no connection is in flight or waiting on it.
+type hostLimiter struct {
+	max   int
+	mu    sync.Mutex
+	hosts map[string]*hostSlots
+}
+
+type hostSlots struct {
+	slots chan struct{}
+	// users is the number of callers holding or waiting for a slot, guarded by hostLimiter mu
+	users int
+}
+
+func newHostLimiter(max int) *hostLimiter {
+	return &hostLimiter{
+		max:   max,
+		hosts: make(map[string]*hostSlots),
+	}
+}
+
+// acquire waits for a free connection slot on host and gives the function to call to release it
+func (l *hostLimiter) acquire(ctx context.Context, host string) (func(), error) {
+	if l.max <= 0 {
+		return func() {}, nil
+	}
+	l.mu.Lock()
+	h, ok := l.hosts[host]
+	if !ok {
+		h = &hostSlots{slots: make(chan struct{}, l.max)}
+		l.hosts[host] = h
+	}
+	h.users++
+	l.mu.Unlock()
+
+	select {
+	case h.slots <- struct{}{}:
+	case <-ctx.Done():
+		l.leave(host, h)
+		return nil, ctx.Err()
+	}
+	once := &sync.Once{}
+	return func() {
+		once.Do(func() {
+			<-h.slots
+			l.leave(host, h)
+		})
+	}, nil
+}
+
+// leave removes a user of host and forgets host when it has no more users
+func (l *hostLimiter) leave(host string, h *hostSlots) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	h.users--
+	if h.users == 0 {
+		delete(l.hosts, host)
+	}
+}
+
+// releaseReadCloser releases host slot when response is closed
+type releaseReadCloser struct {
+	io.ReadCloser
+	release func()
+}
+
+func (r releaseReadCloser) Close() error {
+	err := r.ReadCloser.Close()
+	r.release()
+	return err
+}
@@ -0,0 +1,67 @@
+package scrapers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("hostLimiter", func() {
+	hostsCount := func(l *hostLimiter) int {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return len(l.hosts)
+	}
+
+	It("forgets hosts without connection in flight", func() {
+		l := newHostLimiter(1)
+		release1, err := l.acquire(context.Background(), "host1")
+		Expect(err).ToNot(HaveOccurred())
+		release2, err := l.acquire(context.Background(), "host2")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(hostsCount(l)).To(Equal(2))
+
+		release1()
+		release1()
+		Expect(hostsCount(l)).To(Equal(1))
+		release2()
+		Expect(hostsCount(l)).To(Equal(0))
+	})
+
+	It("keeps host while a caller waits for a slot", func() {
+		l := newHostLimiter(1)
+		release, err := l.acquire(context.Background(), "host")
+		Expect(err).ToNot(HaveOccurred())
+
+		acquired := make(chan func())
+		go func() {
+			defer GinkgoRecover()
+			waitRelease, err := l.acquire(context.Background(), "host")
+			Expect(err).ToNot(HaveOccurred())
+			acquired <- waitRelease
+		}()
+		Consistently(acquired, 50*time.Millisecond).ShouldNot(Receive())
+		release()
+		Expect(hostsCount(l)).To(Equal(1))
+
+		var waitRelease func()
+		Eventually(acquired).Should(Receive(&waitRelease))
+		waitRelease()
+		Expect(hostsCount(l)).To(Equal(0))
+	})
+
+	It("forgets host when waiting caller gives up", func() {
+		l := newHostLimiter(1)
+		release, err := l.acquire(context.Background(), "host")
+		Expect(err).ToNot(HaveOccurred())
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err = l.acquire(ctx, "host")
+		Expect(err).To(HaveOccurred())
+		release()
+		Expect(hostsCount(l)).To(Equal(0))
+	})
+})
@@ -0,0 +1,579 @@
+package scrapers
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	omTypeCounter        = "counter"
+	omTypeGauge          = "gauge"
+	omTypeHistogram      = "histogram"
+	omTypeGaugeHistogram = "gaugehistogram"
+	omTypeSummary        = "summary"
+	omTypeInfo           = "info"
+	omTypeStateSet       = "stateset"
+	omTypeUnknown        = "unknown"
+)
+
+// omSuffixes are the sample name suffixes allowed for each openmetrics type
+var omSuffixes = map[string][]string{
+	omTypeCounter:        {"_total", "_created"},
+	omTypeGauge:          {""},
+	omTypeHistogram:      {"_bucket", "_count", "_sum", "_created"},
+	omTypeGaugeHistogram: {"_bucket", "_gcount", "_gsum"},
+	omTypeSummary:        {"", "_count", "_sum", "_created"},
+	omTypeInfo:           {"_info"},
+	omTypeStateSet:       {""},
+	omTypeUnknown:        {""},
+}
+
+// omFamily is the openmetrics family being parsed
+type omFamily struct {
+	name    string
+	omType  string
+	mf      *dto.MetricFamily
+	metrics map[string]*dto.Metric
+	// createdOnly are metrics having a `_created` sample without the samples it applies to
+	createdOnly map[*dto.Metric]string
+}
+
+type omSample struct {
+	name      string
+	labels    []*dto.LabelPair
+	value     float64
+	timestamp *float64
+	exemplar  *dto.Exemplar
+}
+
+// ParseOpenMetrics parses metrics in openmetrics text format as described in
+// https://github.com/OpenObservability/OpenMetrics/blob/main/specification/OpenMetrics.md
+// Exemplars, units and created timestamps are kept on metric families.
+// Counters are named with their `_total` suffix and info metrics with their `_info` suffix
+// to be exposed as in prometheus text format, info and stateset are converted to gauges.
+func ParseOpenMetrics(r io.Reader) (map[string]*dto.MetricFamily, error) {
+	p := &omParser{
+		metricsGroup: make(map[string]*dto.MetricFamily),
+		seen:         make(map[string]bool),
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	lineNum := 0
+	eof := false
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Text()
+		if eof {
+			if line != "" {
+				return nil, fmt.Errorf("openmetrics line %d: unexpected content after # EOF", lineNum)
+			}
+			continue
+		}
+		if line == "# EOF" {
+			eof = true
+			continue
+		}
+		if err := p.parseLine(line); err != nil {
+			return nil, fmt.Errorf("openmetrics line %d: %s", lineNum, err.Error())
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !eof {
+		return nil, fmt.Errorf("openmetrics: missing # EOF")
+	}
+	if err := p.current.check(); err != nil {
+		return nil, fmt.Errorf("openmetrics: %s", err.Error())
+	}
+	return p.metricsGroup, nil
+}
+
+type omParser struct {
+	metricsGroup map[string]*dto.MetricFamily
+	current      *omFamily
+	seen         map[string]bool
+}
+
+func (p *omParser) parseLine(line string) error {
+	if line == "" {
+		return nil
+	}
+	if strings.HasPrefix(line, "#") {
+		return p.parseDescriptor(line)
+	}
+	sample, err := parseOMSample(line)
+	if err != nil {
+		return err
+	}
+	return p.addSample(sample)
+}
+
+// parseDescriptor parses `# TYPE`, `# HELP` and `# UNIT` lines, other comments are ignored
+func (p *omParser) parseDescriptor(line string) error {
+	parts := strings.SplitN(line, " ", 4)
+	if len(parts) < 3 || parts[0] != "#" {
+		return nil
+	}
+	kind, name := parts[1], parts[2]
+	value := ""
+	if len(parts) == 4 {
+		value = parts[3]
+	}
+	if kind != "TYPE" && kind != "HELP" && kind != "UNIT" {
+		return nil
+	}
+	if !model.IsValidMetricName(model.LabelValue(name)) {
+		return fmt.Errorf("invalid metric name %q", name)
+	}
+	family := p.current
+	if family == nil || family.name != name {
+		var err error
+		family, err = p.startFamily(name, omTypeUnknown)
+		if err != nil {
+			return err
+		}
+	}
+	switch kind {
+	case "TYPE":
+		if _, ok := omSuffixes[value]; !ok {
+			return fmt.Errorf("unknown type %q for metric family %s", value, name)
+		}
+		if len(family.metrics) > 0 {
+			return fmt.Errorf("TYPE for metric family %s must be set before its samples", name)
+		}
+		family.setType(value)
+	case "HELP":
+		family.mf.Help = proto.String(unescapeOM(value))
+	case "UNIT":
+		if value != "" && !strings.HasSuffix(name, "_"+value) {
+			return fmt.Errorf("metric family %s must be suffixed by its unit %s", name, value)
+		}
+		family.mf.Unit = proto.String(value)
+	}
+	return nil
+}
+
+func (p *omParser) startFamily(name, omType string) (*omFamily, error) {
+	if err := p.current.check(); err != nil {
+		return nil, err
+	}
+	if p.seen[name] {
+		return nil, fmt.Errorf("metric family %s is not contiguous", name)
+	}
+	p.seen[name] = true
+	family := &omFamily{
+		name:        name,
+		mf:          &dto.MetricFamily{},
+		metrics:     make(map[string]*dto.Metric),
+		createdOnly: make(map[*dto.Metric]string),
+	}
+	family.setType(omType)
+	p.current = family
+	return family, nil
+}
+
+func (f *omFamily) setType(omType string) {
+	f.omType = omType
+	dtoName := f.name
+	dtoType := dto.MetricType_UNTYPED
+	switch omType {
+	case omTypeCounter:
+		dtoName = f.name + "_total"
+		dtoType = dto.MetricType_COUNTER
+	case omTypeGauge, omTypeStateSet:
+		dtoType = dto.MetricType_GAUGE
+	case omTypeInfo:
+		dtoName = f.name + "_info"
+		dtoType = dto.MetricType_GAUGE
+	case omTypeHistogram:
+		dtoType = dto.MetricType_HISTOGRAM
+	case omTypeGaugeHistogram:
+		dtoType = dto.MetricType_GAUGE_HISTOGRAM
+	case omTypeSummary:
+		dtoType = dto.MetricType_SUMMARY
+	}
+	f.mf.Name = proto.String(dtoName)
+	f.mf.Type = dtoType.Enum()
+}
+
+// check checks that family is complete, a created timestamp must come with the samples it applies to
+func (f *omFamily) check() error {
+	if f == nil {
+		return nil
+	}
+	for _, sampleName := range f.createdOnly {
+		return fmt.Errorf("sample %s is given without the samples of its metric", sampleName)
+	}
+	return nil
+}
+
+// suffix gives the suffix of sample name for this family, false is returned if sample does not belong to family
+func (f *omFamily) suffix(sampleName string) (string, bool) {
+	if !strings.HasPrefix(sampleName, f.name) {
+		return "", false
+	}
+	suffix := strings.TrimPrefix(sampleName, f.name)
+	for _, allowed := range omSuffixes[f.omType] {
+		if suffix == allowed {
+			return suffix, true
+		}
+	}
+	return "", false
+}
+
+func (p *omParser) addSample(sample omSample) error {
+	family := p.current
+	suffix := ""
+	ok := false
+	if family != nil {
+		suffix, ok = family.suffix(sample.name)
+	}
+	if !ok {
+		var err error
+		family, err = p.startFamily(sample.name, omTypeUnknown)
+		if err != nil {
+			return err
+		}
+	}
+	// families without samples are not kept, as with text format
+	p.metricsGroup[family.mf.GetName()] = family.mf
+
+	if sample.exemplar != nil &&
+		!(family.omType == omTypeCounter && suffix == "_total") &&
+		!((family.omType == omTypeHistogram || family.omType == omTypeGaugeHistogram) && suffix == "_bucket") {
+		return fmt.Errorf("exemplars are only allowed on counter total and histogram bucket samples, found one on %s", sample.name)
+	}
+
+	var le, quantile *float64
+	labels := make([]*dto.LabelPair, 0, len(sample.labels))
+	for _, label := range sample.labels {
+		switch {
+		case suffix == "_bucket" && label.GetName() == model.BucketLabel:
+			v, err := parseOMFloat(label.GetValue())
+			if err != nil {
+				return fmt.Errorf("invalid %s label on %s: %s", model.BucketLabel, sample.name, err.Error())
+			}
+			le = &v
+		case family.omType == omTypeSummary && suffix == "" && label.GetName() == model.QuantileLabel:
+			v, err := parseOMFloat(label.GetValue())
+			if err != nil {
+				return fmt.Errorf("invalid %s label on %s: %s", model.QuantileLabel, sample.name, err.Error())
+			}
+			quantile = &v
+		default:
+			labels = append(labels, label)
+		}
+	}
+	metric := family.metric(labels)
+	if suffix == "_created" {
+		if !hasValue(metric) {
+			family.createdOnly[metric] = sample.name
+		}
+	} else {
+		delete(family.createdOnly, metric)
+	}
+	if sample.timestamp != nil {
+		metric.TimestampMs = proto.Int64(int64(*sample.timestamp * 1000))
+	}
+
+	switch family.omType {
+	case omTypeCounter:
+		if metric.Counter == nil {
+			metric.Counter = &dto.Counter{}
+		}
+		if suffix == "_created" {
+			metric.Counter.CreatedTimestamp = omTimestamp(sample.value)
+			return nil
+		}
+		metric.Counter.Value = proto.Float64(sample.value)
+		metric.Counter.Exemplar = sample.exemplar
+	case omTypeGauge, omTypeInfo, omTypeStateSet:
+		metric.Gauge = &dto.Gauge{Value: proto.Float64(sample.value)}
+	case omTypeUnknown:
+		metric.Untyped = &dto.Untyped{Value: proto.Float64(sample.value)}
+	case omTypeSummary:
+		if metric.Summary == nil {
+			metric.Summary = &dto.Summary{}
+		}
+		switch suffix {
+		case "_count":
+			count, err := omCount(sample)
+			if err != nil {
+				return err
+			}
+			metric.Summary.SampleCount = &count
+		case "_sum":
+			metric.Summary.SampleSum = proto.Float64(sample.value)
+		case "_created":
+			metric.Summary.CreatedTimestamp = omTimestamp(sample.value)
+		default:
+			if quantile == nil {
+				return fmt.Errorf("missing %s label on %s", model.QuantileLabel, sample.name)
+			}
+			metric.Summary.Quantile = append(metric.Summary.Quantile, &dto.Quantile{
+				Quantile: quantile,
+				Value:    proto.Float64(sample.value),
+			})
+		}
+	case omTypeHistogram, omTypeGaugeHistogram:
+		if metric.Histogram == nil {
+			metric.Histogram = &dto.Histogram{}
+		}
+		switch suffix {
+		case "_count", "_gcount":
+			count, err := omCount(sample)
+			if err != nil {
+				return err
+			}
+			metric.Histogram.SampleCount = &count
+		case "_sum", "_gsum":
+			metric.Histogram.SampleSum = proto.Float64(sample.value)
+		case "_created":
+			metric.Histogram.CreatedTimestamp = omTimestamp(sample.value)
+		case "_bucket":
+			if le == nil {
+				return fmt.Errorf("missing %s label on %s", model.BucketLabel, sample.name)
+			}
+			count, err := omCount(sample)
+			if err != nil {
+				return err
+			}
+			metric.Histogram.Bucket = append(metric.Histogram.Bucket, &dto.Bucket{
+				UpperBound:      le,
+				CumulativeCount: &count,
+				Exemplar:        sample.exemplar,
+			})
+		}
+	}
+	return nil
+}
+
+// hasValue checks that a sample other than `_created` has been set on metric
+func hasValue(metric *dto.Metric) bool {
+	switch {
+	case metric.Counter != nil:
+		return metric.Counter.Value != nil
+	case metric.Summary != nil:
+		return metric.Summary.SampleCount != nil || metric.Summary.SampleSum != nil || len(metric.Summary.Quantile) > 0
+	case metric.Histogram != nil:
+		return metric.Histogram.SampleCount != nil || metric.Histogram.SampleSum != nil || len(metric.Histogram.Bucket) > 0
+	}
+	return false
+}
+
+// metric gives metric of family with given labels, it is created if not exists
+func (f *omFamily) metric(labels []*dto.LabelPair) *dto.Metric {
+	key := labelsSignature(labels)
+	if metric, ok := f.metrics[key]; ok {
+		return metric
+	}
+	metric := &dto.Metric{Label: labels}
+	f.metrics[key] = metric
+	f.mf.Metric = append(f.mf.Metric, metric)
+	return metric
+}
+
+func labelsSignature(labels []*dto.LabelPair) string {
+	pairs := make([]string, len(labels))
+	for i, label := range labels {
+		pairs[i] = label.GetName() + "\xff" + label.GetValue()
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "\xfe")
+}
+
+// parseOMSample parses a sample line: `name{labels} value [timestamp] [# {labels} value [timestamp]]`
+func parseOMSample(line string) (omSample, error) {
+	sample := omSample{}
+	end := strings.IndexAny(line, "{ ")
+	if end < 0 {
+		return sample, fmt.Errorf("missing value for sample %q", line)
+	}
+	sample.name = line[:end]
+	if !model.IsValidMetricName(model.LabelValue(sample.name)) {
+		return sample, fmt.Errorf("invalid metric name %q", sample.name)
+	}
+	rest := line[end:]
+	if strings.HasPrefix(rest, "{") {
+		labels, remaining, err := parseOMLabels(rest)
+		if err != nil {
+			return sample, fmt.Errorf("invalid labels on %s: %s", sample.name, err.Error())
+		}
+		sample.labels = labels
+		rest = remaining
+	}
+	if !strings.HasPrefix(rest, " ") {
+		return sample, fmt.Errorf("missing value for sample %s", sample.name)
+	}
+	rest = rest[1:]
+
+	exemplarPart := ""
+	if idx := strings.Index(rest, " # "); idx >= 0 {
+		exemplarPart = rest[idx+3:]
+		rest = rest[:idx]
+	}
+	value, timestamp, err := parseOMValueAndTimestamp(rest)
+	if err != nil {
+		return sample, fmt.Errorf("invalid sample %s: %s", sample.name, err.Error())
+	}
+	sample.value = value
+	sample.timestamp = timestamp
+	if exemplarPart != "" {
+		sample.exemplar, err = parseOMExemplar(exemplarPart)
+		if err != nil {
+			return sample, fmt.Errorf("invalid exemplar on %s: %s", sample.name, err.Error())
+		}
+	}
+	return sample, nil
+}
+
+func parseOMExemplar(s string) (*dto.Exemplar, error) {
+	if !strings.HasPrefix(s, "{") {
+		return nil, fmt.Errorf("exemplar must start with a label set")
+	}
+	labels, rest, err := parseOMLabels(s)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(rest, " ") {
+		return nil, fmt.Errorf("missing exemplar value")
+	}
+	value, timestamp, err := parseOMValueAndTimestamp(rest[1:])
+	if err != nil {
+		return nil, err
+	}
+	exemplar := &dto.Exemplar{
+		Label: labels,
+		Value: proto.Float64(value),
+	}
+	if timestamp != nil {
+		exemplar.Timestamp = omTimestamp(*timestamp)
+	}
+	return exemplar, nil
+}
+
+func parseOMValueAndTimestamp(s string) (float64, *float64, error) {
+	fields := strings.Split(s, " ")
+	if len(fields) < 1 || len(fields) > 2 || fields[0] == "" {
+		return 0, nil, fmt.Errorf("expected value and optional timestamp but got %q", s)
+	}
+	value, err := parseOMFloat(fields[0])
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(fields) == 1 {
+		return value, nil, nil
+	}
+	timestamp, err := parseOMFloat(fields[1])
+	if err != nil || math.IsNaN(timestamp) || math.IsInf(timestamp, 0) {
+		return 0, nil, fmt.Errorf("invalid timestamp %q", fields[1])
+	}
+	return value, &timestamp, nil
+}
+
+// parseOMLabels parses a label set starting with `{`, it gives back what remains after the closing `}`
+func parseOMLabels(s string) ([]*dto.LabelPair, string, error) {
+	labels := make([]*dto.LabelPair, 0)
+	i := 1
+	for {
+		if i >= len(s) {
+			return nil, "", fmt.Errorf("unterminated label set")
+		}
+		if s[i] == '}' {
+			return labels, s[i+1:], nil
+		}
+		eq := strings.IndexByte(s[i:], '=')
+		if eq < 0 {
+			return nil, "", fmt.Errorf("missing '=' in label set")
+		}
+		name := s[i : i+eq]
+		if !model.LabelName(name).IsValid() {
+			return nil, "", fmt.Errorf("invalid label name %q", name)
+		}
+		i += eq + 1
+		if i >= len(s) || s[i] != '"' {
+			return nil, "", fmt.Errorf("label value of %s must be quoted", name)
+		}
+		i++
+		var value strings.Builder
+		for ; i < len(s) && s[i] != '"'; i++ {
+			if s[i] != '\\' {
+				value.WriteByte(s[i])
+				continue
+			}
+			i++
+			if i >= len(s) {
+				break
+			}
+			switch s[i] {
+			case 'n':
+				value.WriteByte('\n')
+			case '\\', '"':
+				value.WriteByte(s[i])
+			default:
+				return nil, "", fmt.Errorf("invalid escape sequence '\\%c' in label value of %s", s[i], name)
+			}
+		}
+		if i >= len(s) {
+			return nil, "", fmt.Errorf("unterminated label value of %s", name)
+		}
+		i++
+		labels = append(labels, &dto.LabelPair{Name: proto.String(name), Value: proto.String(value.String())})
+		// labels are separated by a comma, no comma is allowed after last label
+		if i < len(s) && s[i] == ',' {
+			i++
+			if i < len(s) && s[i] == '}' {
+				return nil, "", fmt.Errorf("unexpected ',' after last label")
+			}
+			continue
+		}
+		if i < len(s) && s[i] != '}' {
+			return nil, "", fmt.Errorf("missing ',' after label %s", name)
+		}
+	}
+}
+
+func parseOMFloat(s string) (float64, error) {
+	// go accepts hexadecimal, underscores and other spellings of infinity and nan which are not allowed
+	if strings.ContainsAny(s, "xX_") {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+	if strings.ContainsAny(s, "iInN") && s != "+Inf" && s != "-Inf" && s != "NaN" {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+	return v, nil
+}
+
+func omCount(sample omSample) (uint64, error) {
+	if sample.value < 0 || math.IsNaN(sample.value) || math.IsInf(sample.value, 0) || sample.value != math.Trunc(sample.value) {
+		return 0, fmt.Errorf("sample %s must be a non-negative integer count", sample.name)
+	}
+	return uint64(sample.value), nil
+}
+
+func omTimestamp(seconds float64) *timestamppb.Timestamp {
+	sec, frac := math.Modf(seconds)
+	return &timestamppb.Timestamp{Seconds: int64(sec), Nanos: int32(frac * 1e9)}
+}
+
+func unescapeOM(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	return strings.NewReplacer(`\\`, `\`, `\n`, "\n", `\"`, `"`).Replace(s)
+}
@@ -2,27 +2,48 @@ package scrapers
 
 import (
 	"compress/gzip"
+	"context"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/orange-cloudfoundry/promconsulfetcher/clients"
+	"github.com/orange-cloudfoundry/promconsulfetcher/config"
 	"github.com/orange-cloudfoundry/promconsulfetcher/errors"
 	"github.com/orange-cloudfoundry/promconsulfetcher/models"
 )
 
-const acceptHeader = `application/openmetrics-text; version=0.0.1,text/plain;version=0.0.4;q=0.5,*/*;q=0.1`
+const acceptHeader = `application/vnd.google.protobuf;proto=io.prometheus.client.MetricFamily;encoding=delimited,` +
+	`application/openmetrics-text;version=1.0.0;q=0.8,application/openmetrics-text;version=0.0.1;q=0.75,` +
+	`text/plain;version=0.0.4;q=0.5,*/*;q=0.1`
 
 type Scraper struct {
 	backendFactory *clients.BackendFactory
 	outboundIp     string
+	addressPolicy  models.AddressPolicy
+	hostLimiter    *hostLimiter
+
+	bodySizeLimit           int64
+	compressedBodySizeLimit int64
 }
 
-func NewScraper(backendFactory *clients.BackendFactory) *Scraper {
-	return &Scraper{backendFactory: backendFactory}
+func NewScraper(backendFactory *clients.BackendFactory, c config.Config) *Scraper {
+	addressPolicy := c.AddressPolicy
+	if len(addressPolicy) == 0 {
+		addressPolicy = models.DefaultAddressPolicy
+	}
+	return &Scraper{
+		backendFactory: backendFactory,
+		addressPolicy:  addressPolicy,
+		hostLimiter:    newHostLimiter(int(c.Backends.MaxConns)),
 
+		bodySizeLimit:           c.Limits.BodySizeLimit,
+		compressedBodySizeLimit: c.Limits.CompressedBodySizeLimit,
+	}
 }
 
 func (s *Scraper) GetOutboundIP() string {
@@ -42,30 +63,48 @@ func (s *Scraper) GetOutboundIP() string {
 	return s.outboundIp
 }
 
-func (s Scraper) Scrape(route *models.Route, metricPathDefault, metricSchemeDefault string, headers http.Header) (io.ReadCloser, error) {
-	scheme := metricSchemeDefault
-	routeScheme := route.FindScheme()
-	if routeScheme != "" {
-		scheme = routeScheme
+// Scrape calls metrics endpoint of route, scrapeConfig must be the one resolved for route with models.Route.ScrapeConfig
+// Request is made with given context which can limit scrape timeout with its deadline.
+// Response gives content type sent by route to choose decoder with Decode.
+func (s Scraper) Scrape(ctx context.Context, route *models.Route, scrapeConfig models.ScrapeConfig, headers http.Header) (*Response, error) {
+	scheme := scrapeConfig.Scheme
+	endpoint := scrapeConfig.MetricPath
+	if len(scrapeConfig.Params) > 0 {
+		sep := "?"
+		if strings.Contains(endpoint, "?") {
+			sep = "&"
+		}
+		endpoint = endpoint + sep + scrapeConfig.Params.Encode()
 	}
-	endpoint := metricPathDefault
-	routeMetricPath := route.FindMetricsPath()
-	if routeMetricPath != "" {
-		endpoint = routeMetricPath
+	port := route.ServicePort
+	if scrapeConfig.MetricsPort > 0 {
+		port = scrapeConfig.MetricsPort
 	}
-	portStr := ""
-	if route.ServicePort > 0 {
-		portStr = fmt.Sprintf(":%d", route.ServicePort)
+	addressPolicy := scrapeConfig.AddressPolicy
+	if len(addressPolicy) == 0 {
+		addressPolicy = s.addressPolicy
 	}
-	svcAddr := route.ServiceAddress
+	svcAddr := addressPolicy.Address(route)
 	if svcAddr == "" {
-		svcAddr = route.Address
+		return nil, fmt.Errorf("no address found with address policy %s", addressPolicy.String())
 	}
-	address := svcAddr + portStr
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s://%s%s", scheme, address, endpoint), nil)
+	address := svcAddr
+	if port > 0 {
+		address = net.JoinHostPort(svcAddr, strconv.Itoa(port))
+	} else if strings.Contains(svcAddr, ":") {
+		address = "[" + svcAddr + "]"
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s://%s%s", scheme, address, endpoint), nil)
 	if err != nil {
 		return nil, err
 	}
+	client := s.backendFactory.NewClient(route, address)
+	if scrapeConfig.Timeout > 0 {
+		client.Timeout = scrapeConfig.Timeout
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < client.Timeout {
+		client.Timeout = time.Until(deadline)
+	}
 	if len(headers) > 0 {
 		for k, v := range headers {
 			req.Header[k] = v
@@ -73,17 +112,23 @@ func (s Scraper) Scrape(route *models.Route, metricPathDefault, metricSchemeDefa
 	}
 	req.Header.Add("Accept", acceptHeader)
 	req.Header.Add("Accept-Encoding", "gzip")
-	req.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", fmt.Sprintf("%f", (30*time.Second).Seconds()))
+	req.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", fmt.Sprintf("%f", client.Timeout.Seconds()))
 	req.Header.Set("X-Forwarded-Proto", scheme)
 	req.Header.Set("X-Promconsulfetcher-Scrapping", "true")
 	req.Header.Set("X-Forwarded-For", s.GetOutboundIP())
-	client := s.backendFactory.NewClient(route)
-	resp, err := client.Do(req)
+	release, err := s.hostLimiter.acquire(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(clients.TraceConnections(req))
 	if err != nil {
+		release()
 		return nil, err
 	}
+	resp.Body = releaseReadCloser{ReadCloser: resp.Body, release: release}
 
 	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
 		if resp.StatusCode >= 400 && resp.StatusCode <= 499 {
 			return nil, errors.ErrNoEndpointFound(
 				fmt.Sprintf(
@@ -97,15 +142,25 @@ func (s Scraper) Scrape(route *models.Route, metricPathDefault, metricSchemeDefa
 		return nil, fmt.Errorf("server returned HTTP status %s", resp.Status)
 	}
 
-	if resp.Header.Get("Content-Encoding") != "gzip" {
-		return resp.Body, nil
-	}
-	gzReader, err := NewReaderGzip(resp.Body)
-	if err != nil {
-		resp.Body.Close()
-		return nil, err
+	response := &Response{ContentType: resp.Header.Get("Content-Type")}
+	var body io.ReadCloser = resp.Body
+	if resp.Header.Get("Content-Encoding") == "gzip" {
+		compressed := newSizeLimitReader(resp.Body, CompressedBodySizeLimit, s.compressedBodySizeLimit)
+		response.limitReaders = append(response.limitReaders, compressed)
+		gzReader, err := NewReaderGzip(limitedReadCloser{sizeLimitReader: compressed, closer: resp.Body})
+		if err != nil {
+			resp.Body.Close()
+			if compressed.err != nil {
+				return nil, compressed.err
+			}
+			return nil, err
+		}
+		body = gzReader
 	}
-	return gzReader, nil
+	decompressed := newSizeLimitReader(body, BodySizeLimit, s.bodySizeLimit)
+	response.limitReaders = append(response.limitReaders, decompressed)
+	response.ReadCloser = limitedReadCloser{sizeLimitReader: decompressed, closer: body}
+	return response, nil
 }
 
 type ReaderGzip struct {
@@ -1,12 +1,17 @@
 package scrapers_test
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -29,7 +34,7 @@ var _ = Describe("Scraper", func() {
 		Expect(err).ShouldNot(HaveOccurred())
 
 		backendFactory := clients.NewBackendFactory(*c)
-		scraper = scrapers.NewScraper(backendFactory)
+		scraper = scrapers.NewScraper(backendFactory, *c)
 
 		server = ghttp.NewServer()
 	})
@@ -64,7 +69,7 @@ var _ = Describe("Scraper", func() {
 				ServicePort:    port,
 			}
 
-			resp, err := scraper.Scrape(route, "/metrics", "http", http.Header{})
+			resp, err := scraper.Scrape(context.Background(), route, models.ScrapeConfig{MetricPath: "/metrics", Scheme: "http"}, http.Header{})
 			Expect(err).ShouldNot(HaveOccurred())
 			defer resp.Close()
 
@@ -74,6 +79,141 @@ var _ = Describe("Scraper", func() {
 		})
 	})
 
+	Context("Scrape on dedicated metrics port", func() {
+		var serverURL *url.URL
+		var content = "test_scrape_metrics_port 0"
+		BeforeEach(func() {
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/admin/metrics"),
+					ghttp.RespondWith(http.StatusOK, content),
+				),
+			)
+			serverURL, err = url.Parse(server.URL())
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("replaces service port by metrics port", func() {
+			host, portStr, err := net.SplitHostPort(serverURL.Host)
+			Expect(err).ShouldNot(HaveOccurred())
+			port, err := strconv.Atoi(portStr)
+			Expect(err).ShouldNot(HaveOccurred())
+			route := &models.Route{
+				ID:             "a758f25d-2d01-419e-b63b-de3aabcd9e15",
+				Address:        host,
+				ServiceAddress: host,
+				ServicePort:    1,
+			}
+
+			resp, err := scraper.Scrape(context.Background(), route, models.ScrapeConfig{
+				MetricPath:  "/admin/metrics",
+				Scheme:      "http",
+				MetricsPort: port,
+			}, http.Header{})
+			Expect(err).ShouldNot(HaveOccurred())
+			defer resp.Close()
+
+			body, err := ioutil.ReadAll(resp)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(string(body)).To(Equal(content))
+		})
+	})
+
+	Context("Scrape with a deadline", func() {
+		BeforeEach(func() {
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/metrics"),
+					func(w http.ResponseWriter, req *http.Request) {
+						Expect(req.Header.Get("X-Prometheus-Scrape-Timeout-Seconds")).ToNot(Equal("30.000000"))
+						time.Sleep(200 * time.Millisecond)
+					},
+					ghttp.RespondWith(http.StatusOK, "test_scrape_deadline 0"),
+				),
+			)
+		})
+
+		It("stops scraping when deadline of context is reached", func() {
+			serverURL, err := url.Parse(server.URL())
+			Expect(err).ToNot(HaveOccurred())
+			host, portStr, err := net.SplitHostPort(serverURL.Host)
+			Expect(err).ShouldNot(HaveOccurred())
+			port, err := strconv.Atoi(portStr)
+			Expect(err).ShouldNot(HaveOccurred())
+			route := &models.Route{
+				Address:        host,
+				ServiceAddress: host,
+				ServicePort:    port,
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			_, err = scraper.Scrape(ctx, route, models.ScrapeConfig{MetricPath: "/metrics", Scheme: "http"}, http.Header{})
+			Expect(err).Should(HaveOccurred())
+		})
+	})
+
+	Context("Scrape with body size limits", func() {
+		var route *models.Route
+		var gzipContent []byte
+		BeforeEach(func() {
+			buf := &bytes.Buffer{}
+			gz := gzip.NewWriter(buf)
+			gz.Write([]byte(strings.Repeat("a", 10000)))
+			gz.Close()
+			gzipContent = buf.Bytes()
+
+			serverURL, err := url.Parse(server.URL())
+			Expect(err).ToNot(HaveOccurred())
+			host, portStr, err := net.SplitHostPort(serverURL.Host)
+			Expect(err).ShouldNot(HaveOccurred())
+			port, err := strconv.Atoi(portStr)
+			Expect(err).ShouldNot(HaveOccurred())
+			route = &models.Route{
+				Address:        host,
+				ServiceAddress: host,
+				ServicePort:    port,
+			}
+		})
+
+		It("fails reading a decompressed body bigger than limit", func() {
+			server.AppendHandlers(ghttp.RespondWith(http.StatusOK, gzipContent, http.Header{"Content-Encoding": []string{"gzip"}}))
+			c, err := config.DefaultConfig()
+			Expect(err).ShouldNot(HaveOccurred())
+			c.Limits.BodySizeLimit = 100
+			scraper = scrapers.NewScraper(clients.NewBackendFactory(*c), *c)
+
+			resp, err := scraper.Scrape(context.Background(), route, models.ScrapeConfig{MetricPath: "/metrics", Scheme: "http"}, http.Header{})
+			Expect(err).ShouldNot(HaveOccurred())
+			defer resp.Close()
+
+			body, err := ioutil.ReadAll(resp)
+			Expect(err).Should(HaveOccurred())
+			Expect(body).To(HaveLen(100))
+			Expect(resp.SizeLimitError()).ToNot(BeNil())
+			Expect(resp.SizeLimitError().Limit).To(Equal(scrapers.BodySizeLimit))
+			Expect(resp.SizeLimitError().MaxBytes).To(Equal(int64(100)))
+		})
+
+		It("fails reading a compressed body bigger than limit", func() {
+			server.AppendHandlers(ghttp.RespondWith(http.StatusOK, gzipContent, http.Header{"Content-Encoding": []string{"gzip"}}))
+			c, err := config.DefaultConfig()
+			Expect(err).ShouldNot(HaveOccurred())
+			c.Limits.CompressedBodySizeLimit = int64(len(gzipContent) - 1)
+			scraper = scrapers.NewScraper(clients.NewBackendFactory(*c), *c)
+
+			resp, err := scraper.Scrape(context.Background(), route, models.ScrapeConfig{MetricPath: "/metrics", Scheme: "http"}, http.Header{})
+			Expect(err).ShouldNot(HaveOccurred())
+			defer resp.Close()
+
+			_, err = ioutil.ReadAll(resp)
+			Expect(err).Should(HaveOccurred())
+			Expect(resp.SizeLimitError()).ToNot(BeNil())
+			Expect(resp.SizeLimitError().Limit).To(Equal(scrapers.CompressedBodySizeLimit))
+			Expect(resp.SizeLimitError().MaxBytes).To(Equal(int64(len(gzipContent) - 1)))
+		})
+	})
+
 	Context("GetOutboundIP", func() {
 		It("gets local ip", func() {
 			ip := scraper.GetOutboundIP()